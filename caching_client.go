@@ -0,0 +1,311 @@
+package gotftp
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// cacheBlockSize is the unit CachingClient caches and evicts by. It's
+	// independent of the wire blksize negotiated with the server.
+	cacheBlockSize = 1 << 20 // 1 MiB
+
+	defaultGlobalByteBudget  int64 = 1 << 30   // 1 GiB across every cached file
+	defaultPerFileByteBudget int64 = 100 << 20 // 100 MiB for any one file
+)
+
+// CachingClientOptions bounds how much data a CachingClient holds in
+// memory at once. Zero fields fall back to the package default.
+type CachingClientOptions struct {
+	GlobalByteBudget  int64
+	PerFileByteBudget int64
+}
+
+func (o *CachingClientOptions) setDefaults() {
+	if o.GlobalByteBudget <= 0 {
+		o.GlobalByteBudget = defaultGlobalByteBudget
+	}
+	if o.PerFileByteBudget <= 0 {
+		o.PerFileByteBudget = defaultPerFileByteBudget
+	}
+}
+
+type cacheKey struct {
+	file  string
+	block int64
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// CachingClient turns a TFTP server into a practical backing store for a
+// filesystem that issues overlapping random ReadAt calls, by fronting
+// Client.Get with an LRU cache of fixed-size blocks.
+//
+// TFTP has no byte-range request — an RRQ always starts a transfer at
+// block 1 — so a cache miss fetches the whole file in one sequential
+// transfer, populating every block's cache entry as its DATA arrives
+// (not just the one the caller asked for, since the rest are free once
+// the transfer is already streaming past them). Concurrent ReadAt calls
+// that miss into the same file coalesce onto that one fetch rather than
+// each starting their own.
+//
+// Each fetch runs over its own freshly dialed Client/socket rather than a
+// shared one, the same way the server hands every transfer its own
+// ephemeral TID: a TFTP Client's socket isn't safe for two transfers
+// running at once, and CachingClient exists specifically to let unrelated
+// ReadAt calls run concurrently.
+type CachingClient struct {
+	addr       string
+	clientOpts ClientOptions
+	opts       CachingClientOptions
+
+	mu           sync.Mutex
+	lru          *list.List // of *cacheEntry, most-recently-used at the front
+	elems        map[cacheKey]*list.Element
+	perFileBytes map[string]int64
+	totalBytes   int64
+	// pinned counts, per block, how many in-flight ReadAtContext calls
+	// still need it. evictLocked skips pinned blocks no matter how budget
+	// pressure looks, so a whole-file fetch run for a small PerFileByteBudget
+	// can never evict the very block the caller that triggered it is about
+	// to read.
+	pinned map[cacheKey]int
+
+	fetchMu   sync.Mutex
+	fileFetch map[string]*sync.Mutex
+}
+
+// NewCachingClient builds a CachingClient that dials addr fresh for every
+// cache-miss fetch, using clientOpts for each one.
+func NewCachingClient(addr string, clientOpts ClientOptions, opts CachingClientOptions) *CachingClient {
+	opts.setDefaults()
+	return &CachingClient{
+		addr:         addr,
+		clientOpts:   clientOpts,
+		opts:         opts,
+		lru:          list.New(),
+		elems:        make(map[cacheKey]*list.Element),
+		perFileBytes: make(map[string]int64),
+		pinned:       make(map[cacheKey]int),
+		fileFetch:    make(map[string]*sync.Mutex),
+	}
+}
+
+// ReadAt implements io.ReaderAt semantics for fileName against the remote
+// TFTP tree, reading from cache where possible and fetching on a miss.
+func (cc *CachingClient) ReadAt(fileName string, p []byte, off int64) (int, error) {
+	return cc.ReadAtContext(context.Background(), fileName, p, off)
+}
+
+// ReadAtContext is ReadAt, but a miss's fetch is bounded by ctx.
+func (cc *CachingClient) ReadAtContext(ctx context.Context, fileName string, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	startBlock := off / cacheBlockSize
+	endBlock := (off + int64(len(p)) - 1) / cacheBlockSize
+	cc.pinRange(fileName, startBlock, endBlock)
+	defer cc.unpinRange(fileName, startBlock, endBlock)
+
+	if err := cc.ensureCached(ctx, fileName, off, int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(p) {
+		block := (off + int64(n)) / cacheBlockSize
+		blockOff := int((off + int64(n)) % cacheBlockSize)
+		data, ok := cc.getBlock(cacheKey{file: fileName, block: block})
+		if !ok || blockOff >= len(data) {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		n += copy(p[n:], data[blockOff:])
+	}
+	return n, nil
+}
+
+// ensureCached makes sure every cacheBlockSize block overlapping
+// [off, off+size) is cached, running one whole-file fetch on the first
+// miss it finds. Concurrent callers that miss into the same file block on
+// fileFetch until the in-flight one finishes, then re-check the cache
+// instead of starting their own.
+func (cc *CachingClient) ensureCached(ctx context.Context, fileName string, off, size int64) error {
+	startBlock := off / cacheBlockSize
+	endBlock := (off + size - 1) / cacheBlockSize
+	for block := startBlock; block <= endBlock; block++ {
+		if _, ok := cc.getBlock(cacheKey{file: fileName, block: block}); ok {
+			continue
+		}
+
+		lock := cc.fetchLockFor(fileName)
+		lock.Lock()
+		_, hit := cc.getBlock(cacheKey{file: fileName, block: block})
+		var err error
+		if !hit {
+			err = cc.fetchFile(ctx, fileName)
+		}
+		lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// pinRange marks every block in [startBlock, endBlock] as needed by an
+// in-flight ReadAtContext call, so evictLocked won't reclaim it out from
+// under that call no matter how a concurrent fetch's budget pressure
+// looks. unpinRange releases the same range once the call returns.
+func (cc *CachingClient) pinRange(fileName string, startBlock, endBlock int64) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for block := startBlock; block <= endBlock; block++ {
+		cc.pinned[cacheKey{file: fileName, block: block}]++
+	}
+}
+
+func (cc *CachingClient) unpinRange(fileName string, startBlock, endBlock int64) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for block := startBlock; block <= endBlock; block++ {
+		key := cacheKey{file: fileName, block: block}
+		if cc.pinned[key]--; cc.pinned[key] <= 0 {
+			delete(cc.pinned, key)
+		}
+	}
+}
+
+func (cc *CachingClient) fetchLockFor(fileName string) *sync.Mutex {
+	cc.fetchMu.Lock()
+	defer cc.fetchMu.Unlock()
+	l, ok := cc.fileFetch[fileName]
+	if !ok {
+		l = &sync.Mutex{}
+		cc.fileFetch[fileName] = l
+	}
+	return l
+}
+
+// fetchFile runs one sequential transfer of fileName over a freshly
+// dialed Client, streaming each completed cacheBlockSize chunk into the
+// cache as it arrives rather than waiting for the whole file.
+func (cc *CachingClient) fetchFile(ctx context.Context, fileName string) error {
+	client, err := NewClient(cc.addr, cc.clientOpts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	w := &cachingWriter{cc: cc, file: fileName}
+	if err := client.GetContext(ctx, fileName, w); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+func (cc *CachingClient) getBlock(key cacheKey) ([]byte, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	e, ok := cc.elems[key]
+	if !ok {
+		return nil, false
+	}
+	cc.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// putBlock inserts or refreshes a block, then evicts least-recently-used
+// blocks (wherever they are in the cache) until both the per-file and
+// global byte budgets are satisfied again.
+func (cc *CachingClient) putBlock(key cacheKey, data []byte) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if e, ok := cc.elems[key]; ok {
+		old := e.Value.(*cacheEntry)
+		cc.totalBytes -= int64(len(old.data))
+		cc.perFileBytes[key.file] -= int64(len(old.data))
+		cc.lru.Remove(e)
+		delete(cc.elems, key)
+	}
+
+	elem := cc.lru.PushFront(&cacheEntry{key: key, data: data})
+	cc.elems[key] = elem
+	cc.totalBytes += int64(len(data))
+	cc.perFileBytes[key.file] += int64(len(data))
+
+	cc.evictLocked(key.file)
+}
+
+func (cc *CachingClient) evictLocked(justWrittenFile string) {
+	for cc.perFileBytes[justWrittenFile] > cc.opts.PerFileByteBudget || cc.totalBytes > cc.opts.GlobalByteBudget {
+		back := cc.lru.Back()
+		for back != nil && cc.pinned[back.Value.(*cacheEntry).key] > 0 {
+			back = back.Prev()
+		}
+		if back == nil {
+			// Every remaining block is pinned by an in-flight
+			// ReadAtContext (or there's nothing left to evict): leave
+			// the budget over for now rather than evict data a caller
+			// is still waiting to read out from under it.
+			return
+		}
+		entry := back.Value.(*cacheEntry)
+		cc.lru.Remove(back)
+		delete(cc.elems, entry.key)
+		cc.totalBytes -= int64(len(entry.data))
+		cc.perFileBytes[entry.key.file] -= int64(len(entry.data))
+		if cc.perFileBytes[entry.key.file] <= 0 {
+			delete(cc.perFileBytes, entry.key.file)
+		}
+	}
+}
+
+// cachingWriter implements io.WriterAt over a Client.GetContext transfer,
+// committing each cacheBlockSize-aligned chunk to the cache as soon as
+// enough contiguous bytes have arrived, instead of buffering the whole
+// file before it's usable.
+type cachingWriter struct {
+	cc      *CachingClient
+	file    string
+	buf     []byte // bytes received but not yet committed to a full cache block
+	nextOff int64  // file offset buf[0] represents
+}
+
+func (w *cachingWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off != w.nextOff+int64(len(w.buf)) {
+		return 0, fmt.Errorf("gotftp: caching writer for %q got out-of-order write at %d, expected %d", w.file, off, w.nextOff+int64(len(w.buf)))
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= cacheBlockSize {
+		block := w.nextOff / cacheBlockSize
+		data := make([]byte, cacheBlockSize)
+		copy(data, w.buf[:cacheBlockSize])
+		w.cc.putBlock(cacheKey{file: w.file, block: block}, data)
+		w.buf = w.buf[cacheBlockSize:]
+		w.nextOff += cacheBlockSize
+	}
+	return len(p), nil
+}
+
+// flush commits whatever's left after the transfer ends: the final,
+// possibly short, block.
+func (w *cachingWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	block := w.nextOff / cacheBlockSize
+	data := make([]byte, len(w.buf))
+	copy(data, w.buf)
+	w.cc.putBlock(cacheKey{file: w.file, block: block}, data)
+	w.nextOff += int64(len(w.buf))
+	w.buf = nil
+	return nil
+}
@@ -0,0 +1,60 @@
+package gotftp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestCachingServer starts a real Server over loopback UDP backed by
+// an in-memory FileHandler, and returns its address for a CachingClient
+// to dial.
+func newTestCachingServer(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	handler := newMemFileHandler()
+	for name, data := range files {
+		handler.files[name] = data
+	}
+	srv, err := NewServer("127.0.0.1:0", handler, time.Second)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.RunContext(ctx)
+	return srv.LocalAddr().String()
+}
+
+// TestCachingClientReadAtSurvivesItsOwnFetchEviction covers the chunk1-5
+// gap: a whole-file fetch for a file bigger than PerFileByteBudget used to
+// evict the earliest blocks it had just written (including the one the
+// triggering ReadAt actually asked for) before the caller ever read them,
+// so the read that caused the fetch would itself miss and return io.EOF.
+func TestCachingClientReadAtSurvivesItsOwnFetchEviction(t *testing.T) {
+	const fileSize = 5 << 20 // 5 MiB, five cacheBlockSize blocks
+	data := bytes.Repeat([]byte("x"), fileSize)
+	for i := range data {
+		data[i] = byte(i / cacheBlockSize) // each block's bytes all equal its block index
+	}
+	addr := newTestCachingServer(t, map[string][]byte{"big.bin": data})
+
+	cc := NewCachingClient(addr, ClientOptions{}, CachingClientOptions{
+		GlobalByteBudget:  2 << 20, // 2 MiB: smaller than the 5 MiB file
+		PerFileByteBudget: 2 << 20,
+	})
+
+	buf := make([]byte, 4096)
+	n, err := cc.ReadAt("big.bin", buf, 0)
+	if err != nil {
+		t.Fatalf("ReadAt at offset 0 after populating fetch: %v", err)
+	}
+	if n != len(buf) {
+		t.Fatalf("n = %d, want %d", n, len(buf))
+	}
+	if !bytes.Equal(buf, data[:len(buf)]) {
+		t.Fatalf("got %v, want %v", buf[:8], data[:8])
+	}
+}
@@ -0,0 +1,345 @@
+package gotftp
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+// CachingFileHandler wraps a FileHandler with an in-memory LRU cache of
+// fixed-size blocks, for files many peers fetch concurrently (a PXE
+// kernel/initrd being the canonical case) where re-reading the same bytes
+// from the real backing store on every RRQ is wasted work.
+//
+// The cache is two-level: an outer LRU of files, each holding its own
+// inner LRU of blocks. Evicting a whole file at once (rather than only
+// ever trimming individual blocks) is what keeps a single enormous file
+// from starving every other cached file's budget down to nothing.
+type CachingFileHandler struct {
+	inner      FileHandler
+	blockSize  int64
+	totalBytes int64 // global byte budget across every cached file
+	fileBytes  int64 // per-file byte budget
+
+	mu        sync.Mutex
+	fileLRU   *list.List // of *cachedFile, most-recently-used at the front
+	files     map[string]*list.Element
+	usedBytes int64
+
+	fetchMu    sync.Mutex
+	blockFetch map[cachedBlockKey]*sync.Mutex
+}
+
+type cachedBlockKey struct {
+	file  string
+	block int64
+}
+
+type cachedFile struct {
+	name     string
+	size     int64      // inner file size as of the last populate, for invalidation
+	blockLRU *list.List // of *cachedBlock, most-recently-used at the front
+	blocks   map[int64]*list.Element
+	bytes    int64
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// NewCachingFileHandler wraps inner with a block cache bounded by
+// totalBytes overall and perFileBytes for any one file, caching
+// blockSize-byte chunks. Zero or negative budgets/blockSize fall back to
+// sane defaults (1 GiB / 100 MiB / 1 MiB, matching CachingClient).
+func NewCachingFileHandler(inner FileHandler, totalBytes, perFileBytes, blockSize int64) *CachingFileHandler {
+	if totalBytes <= 0 {
+		totalBytes = defaultGlobalByteBudget
+	}
+	if perFileBytes <= 0 {
+		perFileBytes = defaultPerFileByteBudget
+	}
+	if blockSize <= 0 {
+		blockSize = cacheBlockSize
+	}
+	return &CachingFileHandler{
+		inner:      inner,
+		blockSize:  blockSize,
+		totalBytes: totalBytes,
+		fileBytes:  perFileBytes,
+		fileLRU:    list.New(),
+		files:      make(map[string]*list.Element),
+		blockFetch: make(map[cachedBlockKey]*sync.Mutex),
+	}
+}
+
+// IsFileExist delegates to inner: existence isn't worth caching, since
+// it's already cheap and it's the one check that must never return stale
+// results.
+func (c *CachingFileHandler) IsFileExist(remoteAddr, fileName string) (bool, error) {
+	return c.inner.IsFileExist(remoteAddr, fileName)
+}
+
+// ReadFile returns a ReadSeekCloser that serves fileName's content out of
+// the block cache, populating it from inner on a miss.
+func (c *CachingFileHandler) ReadFile(remoteAddr, fileName string) (ReadSeekCloser, error) {
+	return &cachingReader{c: c, remoteAddr: remoteAddr, file: fileName}, nil
+}
+
+// WriteFile delegates to inner, but invalidates fileName's cache entry
+// once the write completes, so a subsequent read doesn't serve stale
+// blocks from before the overwrite.
+func (c *CachingFileHandler) WriteFile(remoteAddr, fileName string) (WriteSeekCloser, error) {
+	w, err := c.inner.WriteFile(remoteAddr, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{WriteSeekCloser: w, c: c, file: fileName}, nil
+}
+
+// Invalidate drops every cached block for fileName, for callers that know
+// the backing file changed out from under this handler (e.g. replaced on
+// disk by something other than a WriteFile through this same handler).
+func (c *CachingFileHandler) Invalidate(fileName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropFileLocked(fileName)
+}
+
+func (c *CachingFileHandler) dropFileLocked(fileName string) {
+	e, ok := c.files[fileName]
+	if !ok {
+		return
+	}
+	c.fileLRU.Remove(e)
+	delete(c.files, fileName)
+	c.usedBytes -= e.Value.(*cachedFile).bytes
+}
+
+// fileSize opens fileName through inner just far enough to learn its
+// current length, the same way applyReadOptions learns tsize: there's no
+// stat-like method on FileHandler, only Seek on an opened ReadSeekCloser.
+func (c *CachingFileHandler) fileSize(remoteAddr, fileName string) (int64, error) {
+	r, err := c.inner.ReadFile(remoteAddr, fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return r.Seek(0, io.SeekEnd)
+}
+
+// getBlock returns the cached contents of fileName's block index,
+// fetching it from inner on a miss. Concurrent misses for the same
+// (file, block) coalesce onto one fetch via blockFetch, so a dozen peers
+// requesting the same cold block don't each open the backing file.
+func (c *CachingFileHandler) getBlock(remoteAddr, fileName string, block, size int64) ([]byte, error) {
+	if data, ok := c.lookupBlock(fileName, block, size); ok {
+		return data, nil
+	}
+
+	lock := c.fetchLockFor(fileName, block)
+	lock.Lock()
+	defer lock.Unlock()
+	if data, ok := c.lookupBlock(fileName, block, size); ok {
+		return data, nil
+	}
+
+	r, err := c.inner.ReadFile(remoteAddr, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := r.Seek(block*c.blockSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, c.blockSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	data := buf[:n]
+	c.putBlock(fileName, block, data, size)
+	return data, nil
+}
+
+func (c *CachingFileHandler) fetchLockFor(fileName string, block int64) *sync.Mutex {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+	key := cachedBlockKey{file: fileName, block: block}
+	l, ok := c.blockFetch[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.blockFetch[key] = l
+	}
+	return l
+}
+
+func (c *CachingFileHandler) lookupBlock(fileName string, block, size int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fe, ok := c.files[fileName]
+	if !ok {
+		return nil, false
+	}
+	cf := fe.Value.(*cachedFile)
+	if cf.size != size {
+		// the backing file's length moved since we last populated it;
+		// treat the whole thing as stale rather than risk serving blocks
+		// spliced together from two different versions of the file.
+		c.dropFileLocked(fileName)
+		return nil, false
+	}
+	be, ok := cf.blocks[block]
+	if !ok {
+		return nil, false
+	}
+	c.fileLRU.MoveToFront(fe)
+	cf.blockLRU.MoveToFront(be)
+	return be.Value.(*cachedBlock).data, true
+}
+
+func (c *CachingFileHandler) putBlock(fileName string, block int64, data []byte, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fe, ok := c.files[fileName]
+	var cf *cachedFile
+	if ok {
+		cf = fe.Value.(*cachedFile)
+		if cf.size != size {
+			c.dropFileLocked(fileName)
+			ok = false
+		}
+	}
+	if !ok {
+		cf = &cachedFile{name: fileName, size: size, blockLRU: list.New(), blocks: make(map[int64]*list.Element)}
+		fe = c.fileLRU.PushFront(cf)
+		c.files[fileName] = fe
+	} else {
+		c.fileLRU.MoveToFront(fe)
+	}
+
+	if be, exists := cf.blocks[block]; exists {
+		old := be.Value.(*cachedBlock)
+		cf.bytes -= int64(len(old.data))
+		c.usedBytes -= int64(len(old.data))
+		cf.blockLRU.Remove(be)
+		delete(cf.blocks, block)
+	}
+
+	be := cf.blockLRU.PushFront(&cachedBlock{index: block, data: data})
+	cf.blocks[block] = be
+	cf.bytes += int64(len(data))
+	c.usedBytes += int64(len(data))
+
+	for cf.bytes > c.fileBytes {
+		back := cf.blockLRU.Back()
+		if back == nil {
+			break
+		}
+		entry := back.Value.(*cachedBlock)
+		cf.blockLRU.Remove(back)
+		delete(cf.blocks, entry.index)
+		cf.bytes -= int64(len(entry.data))
+		c.usedBytes -= int64(len(entry.data))
+	}
+
+	for c.usedBytes > c.totalBytes {
+		back := c.fileLRU.Back()
+		if back == nil || back == fe {
+			// don't evict the file we just populated out from under
+			// ourselves; its own per-file budget already bounds it.
+			break
+		}
+		victim := back.Value.(*cachedFile)
+		c.fileLRU.Remove(back)
+		delete(c.files, victim.name)
+		c.usedBytes -= victim.bytes
+	}
+}
+
+// cachingReader is the ReadSeekCloser CachingFileHandler.ReadFile hands
+// back: it looks like any other open file to a clientPeer, but every Read
+// is actually served out of the block cache.
+type cachingReader struct {
+	c          *CachingFileHandler
+	remoteAddr string
+	file       string
+	pos        int64
+	size       int64
+	sizeKnown  bool
+}
+
+func (r *cachingReader) stat() error {
+	if r.sizeKnown {
+		return nil
+	}
+	size, err := r.c.fileSize(r.remoteAddr, r.file)
+	if err != nil {
+		return err
+	}
+	r.size, r.sizeKnown = size, true
+	return nil
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	if err := r.stat(); err != nil {
+		return 0, err
+	}
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	block := r.pos / r.c.blockSize
+	blockOff := r.pos % r.c.blockSize
+	data, err := r.c.getBlock(r.remoteAddr, r.file, block, r.size)
+	if err != nil {
+		return 0, err
+	}
+	if blockOff >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[blockOff:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *cachingReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd || !r.sizeKnown {
+		if err := r.stat(); err != nil {
+			return 0, err
+		}
+	}
+	var newPos int64
+	switch whence {
+	case io.SeekStart:
+		newPos = offset
+	case io.SeekCurrent:
+		newPos = r.pos + offset
+	case io.SeekEnd:
+		newPos = r.size + offset
+	default:
+		return 0, errInvalidReq
+	}
+	r.pos = newPos
+	return r.pos, nil
+}
+
+func (r *cachingReader) Close() error {
+	return nil
+}
+
+// invalidatingWriter drops fileName's cache entry once the underlying
+// write finishes, so a read started after this Close sees the new
+// content instead of whatever was cached from before the overwrite.
+type invalidatingWriter struct {
+	WriteSeekCloser
+	c    *CachingFileHandler
+	file string
+}
+
+func (w *invalidatingWriter) Close() error {
+	err := w.WriteSeekCloser.Close()
+	w.c.Invalidate(w.file)
+	return err
+}
@@ -1,22 +1,112 @@
 package gotftp
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"io"
+	"log/slog"
 	"net"
+	"strconv"
 	"time"
+
+	wire "github.com/eahydra/gotftp/packet"
 )
 
+func parseUint(s string) (int, error) {
+	return strconv.Atoi(s)
+}
+
+// isTimeout reports whether err represents an ordinary retry-worthy wait
+// expiring, across every Channel implementation: a net.Error timeout from
+// UDPChannel, or context.DeadlineExceeded from a Channel (like
+// memchannel's) that signals timeouts through ctx instead.
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// busyBackoff returns how long to wait before retrying a request after the
+// server reported ErrBusy, doubling with each consecutive busy attempt.
+func busyBackoff(attempt int, base time.Duration) time.Duration {
+	return base << uint(attempt)
+}
+
+// waitBusyBackoff blocks for d, or returns ctx.Err() early if ctx is done first.
+func waitBusyBackoff(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ClientOptions configures a Client. Any zero-valued field falls back to
+// the package default, so callers only need to set what they care about.
+type ClientOptions struct {
+	BlockSize  int           // RFC 2348 blksize, 8..65464
+	WindowSize int           // RFC 7440 windowsize, 1..65535
+	Timeout    time.Duration // per-packet ACK/DATA wait before retrying
+	Retries    int           // retransmit attempts before giving up
+	// Logger receives diagnostic output from this Client; any *slog.Logger
+	// works, including slog.Default(). Defaults to a logger that discards
+	// everything.
+	Logger *slog.Logger
+	// Hooks lets a caller observe this Client's transfers (start/end/
+	// per-block) for metrics, without forking the package. Zero value
+	// does nothing.
+	Hooks Hooks
+}
+
+func (o *ClientOptions) setDefaults() {
+	if o.BlockSize <= 0 {
+		o.BlockSize = modernBlockSize
+	}
+	if o.WindowSize <= 0 {
+		o.WindowSize = 1
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 3 * time.Second
+	}
+	if o.Retries <= 0 {
+		o.Retries = 3
+	}
+	if o.Logger == nil {
+		o.Logger = discardLogger()
+	}
+}
+
+// Client is a TFTP client. It drives the same RRQ/WRQ/DATA/ACK/OACK state
+// machine the server's clientPeer does, over a wire.Channel — by default a
+// wire.UDPChannel, but any Channel (an in-memory one for tests, a secure
+// one from packet/dtls, or something else entirely) works just as well,
+// since the state machine below never touches a net.PacketConn directly.
 type Client struct {
-	remoteAddr net.Addr
-	conn       net.PacketConn
-	timeout    time.Duration
-	retryTime  int
+	remoteAddr   net.Addr
+	conn         net.PacketConn // nil when NewClientWithChannel supplied channel directly
+	channel      wire.Channel
+	opts         ClientOptions
+	transferSize int64 // tsize the server reported for the last RRQ, 0 if it never said
+
+	// announceTransferSize, if set via SetTransferSize, is sent as tsize
+	// on the next PutContext's WRQ so the server learns the file size up
+	// front instead of only discovering it at the short final block.
+	announceTransferSize int64
 }
 
-func NewClient(addr string, timeout time.Duration, retryTime int) (*Client, error) {
-	var raddr net.Addr
+// SetTransferSize tells the next PutContext call to announce n as tsize
+// in its WRQ.
+func (c *Client) SetTransferSize(n int64) {
+	c.announceTransferSize = n
+}
+
+// NewClient dials addr over UDP and returns a ready-to-use Client.
+func NewClient(addr string, opts ClientOptions) (*Client, error) {
+	opts.setDefaults()
 	raddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -27,128 +117,432 @@ func NewClient(addr string, timeout time.Duration, retryTime int) (*Client, erro
 	}
 	return &Client{
 		conn:       conn,
+		channel:    wire.NewUDPChannel(conn),
 		remoteAddr: raddr,
-		timeout:    timeout,
-		retryTime:  retryTime,
+		opts:       opts,
 	}, nil
 }
 
+// NewClientWithChannel builds a Client on top of an already-constructed
+// Channel talking to remoteAddr, e.g. a packet/dtls.Channel wrapping a
+// secured connection, or a packet/memchannel.Channel in a test.
+func NewClientWithChannel(channel wire.Channel, remoteAddr net.Addr, opts ClientOptions) *Client {
+	opts.setDefaults()
+	return &Client{channel: channel, remoteAddr: remoteAddr, opts: opts}
+}
+
 func (c *Client) Close() error {
-	return c.conn.Close()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) requestPacket(fileName string) []wire.Option {
+	opts := []wire.Option{
+		{Name: "blksize", Value: strconv.Itoa(c.opts.BlockSize)},
+		{Name: "timeout", Value: strconv.Itoa(int(c.opts.Timeout / time.Second))},
+	}
+	if c.opts.WindowSize > 1 {
+		opts = append(opts, wire.Option{Name: "windowsize", Value: strconv.Itoa(c.opts.WindowSize)})
+	}
+	return opts
+}
+
+// acceptOptions adopts whatever the server actually echoed back in an
+// OACK, since it may have shrunk blksize/windowsize/timeout from what we
+// asked for.
+func (c *Client) acceptOptions(opts []wire.Option) {
+	for _, opt := range opts {
+		switch opt.Name {
+		case "blksize":
+			if n, err := parseUint(opt.Value); err == nil {
+				c.opts.BlockSize = n
+			}
+		case "timeout":
+			if n, err := parseUint(opt.Value); err == nil {
+				c.opts.Timeout = time.Duration(n) * time.Second
+			}
+		case "windowsize":
+			if n, err := parseUint(opt.Value); err == nil {
+				c.opts.WindowSize = n
+			}
+		case "tsize":
+			if n, err := parseUint(opt.Value); err == nil {
+				c.transferSize = int64(n)
+			}
+		}
+	}
+}
+
+// TransferSize returns the tsize the server reported in the OACK for the
+// most recent GetContext call, learned up front (before any DATA arrives)
+// by requesting tsize=0 in the RRQ. It's 0 if the server never answered
+// with one, e.g. because it answered the RRQ with DATA straight away and
+// sent no OACK at all.
+func (c *Client) TransferSize() int64 {
+	return c.transferSize
+}
+
+// sendErrorReq tells addr that we're aborting, best-effort: a failure to
+// deliver it isn't worth reporting over whatever error led us to send it.
+func (c *Client) sendErrorReq(ctx context.Context, addr net.Addr, msg string) {
+	c.opts.Logger.Warn("aborting transfer", "remote", addr.String(), "msg", msg)
+	c.channel.WritePacket(ctx, &wire.Error{Code: 0, Msg: msg}, addr)
 }
 
-func (c *Client) Get(fileName string, writer io.WriterAt) error {
-	rrq := bytes.NewBuffer(nil)
-	binary.Write(rrq, binary.BigEndian, uint16(0x01))
-	rrq.WriteString(fileName)
-	rrq.WriteByte(0)
-	rrq.WriteString("octet")
-	rrq.WriteByte(0)
-	if _, err := c.conn.WriteTo(rrq.Bytes(), c.remoteAddr); err != nil {
+// Get fetches remoteFile from the server, writing it to writer and
+// retrying each unanswered request up to c.opts.Retries times. It never
+// returns early; use GetContext to bound the transfer from the outside.
+func (c *Client) Get(remoteFile string, writer io.WriterAt) error {
+	return c.GetContext(context.Background(), remoteFile, writer)
+}
+
+// GetContext is Get, but every wait for a DATA/OACK packet is also bounded
+// by ctx: once ctx is done, the in-flight transfer is aborted with an
+// Error packet telling the server why, and GetContext returns ctx.Err().
+func (c *Client) GetContext(ctx context.Context, remoteFile string, writer io.WriterAt) (err error) {
+	c.transferSize = 0
+	opts := append(c.requestPacket(remoteFile), wire.Option{Name: "tsize", Value: "0"})
+	rrq := &wire.RRQ{FileName: remoteFile, Mode: "octet", Options: opts}
+	if err := c.channel.WritePacket(ctx, rrq, c.remoteAddr); err != nil {
 		return err
 	}
 
-	data := make([]byte, 1024)
-	retryTime := 0
-readLoop:
+	start := time.Now()
+	var received int64
+	c.opts.Hooks.onTransferStart(c.remoteAddr.String(), remoteFile, OpRead)
+	defer func() {
+		c.opts.Hooks.onTransferEnd(c.remoteAddr.String(), remoteFile, OpRead, received, time.Since(start), err)
+	}()
+
+	raddr := c.remoteAddr
+	var blockID uint16 = 1
+	gotOACK := false
+	busyAttempts := 0
+	// lastSent is what readWithRetry retransmits on a timeout: the RRQ
+	// until the handshake finishes, then whichever ACK last slid the
+	// window, so a lost DATA within a window re-prompts the server's own
+	// sorcerer's-apprentice retransmit instead of restarting the request.
+	var lastSent wire.Packet = rrq
+	sendAck := func(id uint16) error {
+		ack := &wire.Ack{BlockID: id}
+		if err := c.channel.WritePacket(ctx, ack, raddr); err != nil {
+			return err
+		}
+		lastSent = ack
+		return nil
+	}
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
-		n, remoteAddr, err := c.conn.ReadFrom(data)
+		pkt, newAddr, err := c.readWithRetry(ctx, raddr, lastSent)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && retryTime < c.retryTime {
-				retryTime++
-				continue
-			}
 			return err
 		}
-		retryTime = 0
-		buff := bytes.NewBuffer(data[:n])
-		var operation uint16
-		if err = binary.Read(buff, binary.BigEndian, &operation); err != nil {
-			continue
-		}
-		switch operation {
-		case 3: // data packet
-			{
-				var blockID uint16
-				if err := binary.Read(buff, binary.BigEndian, &blockID); err != nil {
-					continue readLoop
-				}
-				content := buff.Next(buff.Len())
-				if _, err := writer.WriteAt(content, int64(blockID-1)*512); err == nil {
-					ackpacket := []byte{0x00, 0x04, 0x00, 0x00}
-					binary.BigEndian.PutUint16(ackpacket[2:], blockID)
-					c.conn.WriteTo(ackpacket, remoteAddr)
+
+		switch t := pkt.(type) {
+		case *wire.OAck:
+			raddr = newAddr
+			gotOACK = true
+			c.acceptOptions(t.Options)
+			// ACK block 0 to confirm the negotiated options and kick off DATA.
+			if err := sendAck(0); err != nil {
+				return err
+			}
+		case *wire.Data:
+			raddr = newAddr
+			if blockID == 1 && !gotOACK {
+				// The server answered our request with DATA instead of an
+				// OACK, so it never actually accepted our requested
+				// blksize: fall back to the RFC 1350 default rather than
+				// judging the final block against a size we only asked
+				// for, not negotiated.
+				c.opts.BlockSize = legacyBlockSize
+			}
+			if t.BlockID < blockID {
+				// stale retransmit of a block we already wrote: ack it again
+				// so the server's window keeps sliding instead of timing out.
+				if err := sendAck(t.BlockID); err != nil {
+					return err
 				}
-				if len(content) < 512 {
-					break readLoop
+				continue
+			}
+			if t.BlockID != blockID {
+				// out-of-order block from ahead in the window; drop it and
+				// re-ack the last in-order block we have, so a gap in the
+				// window prompts the server's sorcerer's-apprentice
+				// retransmit instead of us just timing out silently.
+				if err := sendAck(blockID - 1); err != nil {
+					return err
 				}
+				continue
+			}
+			if _, err := writer.WriteAt(t.Data, int64(blockID-1)*int64(c.opts.BlockSize)); err != nil {
+				c.sendErrorReq(ctx, raddr, err.Error())
+				return err
+			}
+			received += int64(len(t.Data))
+			c.opts.Hooks.onBlock(blockID, len(t.Data))
+			if err := sendAck(blockID); err != nil {
+				return err
 			}
-		case 5: // error packet
-			{
-				return handleError(buff)
+			if len(t.Data) < c.opts.BlockSize {
+				return nil
+			}
+			blockID++
+		case *wire.Error:
+			if t.Code == ErrBusy {
+				if busyAttempts >= c.opts.Retries {
+					return &TFTPError{Code: t.Code, Msg: t.Msg}
+				}
+				busyAttempts++
+				if err := waitBusyBackoff(ctx, busyBackoff(busyAttempts, c.opts.Timeout)); err != nil {
+					c.sendErrorReq(ctx, raddr, err.Error())
+					return err
+				}
+				if err := c.channel.WritePacket(ctx, rrq, c.remoteAddr); err != nil {
+					return err
+				}
+				continue
 			}
+			return &TFTPError{Code: t.Code, Msg: t.Msg}
+		default:
+			// Some packet we have no use for right now, e.g. a stray
+			// retransmit from an unrelated, already-finished transfer
+			// sharing this socket: RFC 1350 says to just ignore it rather
+			// than tear down an otherwise-healthy transfer over it.
+			continue
 		}
 	}
-	return nil
 }
 
-func (c *Client) Put(fileName string, reader io.ReaderAt) error {
-	wrq := bytes.NewBuffer(nil)
-	binary.Write(wrq, binary.BigEndian, uint16(0x02))
-	wrq.WriteString(fileName)
-	wrq.WriteByte(0)
-	wrq.WriteString("octet")
-	wrq.WriteByte(0)
-	if _, err := c.conn.WriteTo(wrq.Bytes(), c.remoteAddr); err != nil {
-		return err
+// readWithRetry waits for the next packet on c.channel, resending req and
+// retrying up to c.opts.Retries times on a timeout. An RRQ/WRQ is resent
+// to c.remoteAddr; anything else (an ACK) goes to raddr, the TID the
+// server answered from. A ctx cancellation is always terminal, never
+// retried.
+func (c *Client) readWithRetry(ctx context.Context, raddr net.Addr, req wire.Packet) (wire.Packet, net.Addr, error) {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		pkt, newAddr, err := c.channel.ReadPacket(attemptCtx)
+		cancel()
+		if err == nil {
+			return pkt, newAddr, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			c.sendErrorReq(ctx, raddr, ctxErr.Error())
+			return nil, raddr, ctxErr
+		}
+		if !isTimeout(err) || attempt >= c.opts.Retries {
+			return nil, raddr, err
+		}
+		dst := raddr
+		switch req.(type) {
+		case *wire.RRQ, *wire.WRQ:
+			dst = c.remoteAddr
+		}
+		if err := c.channel.WritePacket(ctx, req, dst); err != nil {
+			return nil, raddr, err
+		}
 	}
-	data := make([]byte, 1024)
-	retryTime := 0
-writeLoop:
+}
+
+// Put sends reader to the server as remoteFile, retrying each unanswered
+// request up to c.opts.Retries times. It never returns early; use
+// PutContext to bound the transfer from the outside.
+func (c *Client) Put(remoteFile string, reader io.ReaderAt) error {
+	return c.PutContext(context.Background(), remoteFile, reader)
+}
+
+// writeHandshake sends wrq, accepts the resulting OACK/ACK, and returns the
+// address the server answered from. An ErrBusy reply is retried with
+// exponential backoff rather than treated as terminal; any other error
+// reply, or running out of busy retries, ends the transfer.
+func (c *Client) writeHandshake(ctx context.Context, wrq *wire.WRQ) (net.Addr, error) {
+	raddr := c.remoteAddr
+	busyAttempts := 0
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
-		n, remoteAddr, err := c.conn.ReadFrom(data)
+		pkt, newAddr, err := c.readWithRetry(ctx, raddr, wrq)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && retryTime < c.retryTime {
-				retryTime++
-				continue
+			return raddr, err
+		}
+		raddr = newAddr
+
+		switch t := pkt.(type) {
+		case *wire.OAck:
+			c.acceptOptions(t.Options)
+			return raddr, nil
+		case *wire.Ack:
+			if t.BlockID != 0 {
+				return raddr, errInvalidReq
 			}
-			return err
+			return raddr, nil
+		case *wire.Error:
+			if t.Code != ErrBusy {
+				return raddr, &TFTPError{Code: t.Code, Msg: t.Msg}
+			}
+			if busyAttempts >= c.opts.Retries {
+				return raddr, &TFTPError{Code: t.Code, Msg: t.Msg}
+			}
+			busyAttempts++
+			if err := waitBusyBackoff(ctx, busyBackoff(busyAttempts, c.opts.Timeout)); err != nil {
+				c.sendErrorReq(ctx, raddr, err.Error())
+				return raddr, err
+			}
+			if err := c.channel.WritePacket(ctx, wrq, c.remoteAddr); err != nil {
+				return raddr, err
+			}
+		default:
+			// Ignore anything that isn't a handshake response, e.g. a
+			// stray retransmit from an unrelated, already-finished
+			// transfer sharing this socket, and keep waiting for the
+			// real one instead of aborting over noise.
+			raddr = c.remoteAddr
 		}
-		retryTime = 0
-		buff := bytes.NewBuffer(data[:n])
-		var operation uint16
-		if err = binary.Read(buff, binary.BigEndian, &operation); err != nil {
-			continue
+	}
+}
+
+// PutContext is Put, but every wait for an ACK/OACK packet is also bounded
+// by ctx: once ctx is done, the in-flight transfer is aborted with an
+// Error packet telling the server why, and PutContext returns ctx.Err().
+func (c *Client) PutContext(ctx context.Context, remoteFile string, reader io.ReaderAt) (err error) {
+	opts := c.requestPacket(remoteFile)
+	if c.announceTransferSize > 0 {
+		opts = append(opts, wire.Option{Name: "tsize", Value: strconv.FormatInt(c.announceTransferSize, 10)})
+	}
+	wrq := &wire.WRQ{FileName: remoteFile, Mode: "octet", Options: opts}
+	if err := c.channel.WritePacket(ctx, wrq, c.remoteAddr); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var sent int64
+	c.opts.Hooks.onTransferStart(c.remoteAddr.String(), remoteFile, OpWrite)
+	defer func() {
+		c.opts.Hooks.onTransferEnd(c.remoteAddr.String(), remoteFile, OpWrite, sent, time.Since(start), err)
+	}()
+
+	raddr, err := c.writeHandshake(ctx, wrq)
+	if err != nil {
+		return err
+	}
+
+	// window holds every DATA block read but not yet acked, so the whole
+	// thing can be resent verbatim on a timeout: per RFC 7440, the server
+	// drops back to the last in-order block on a lost ACK, so the client
+	// must resume from there rather than just the one block that timed out.
+	window := make(map[uint16]*wire.Data)
+	var sendBase uint16 = 1
+	var nextBlock uint16 = 1
+	haveFinal := false
+	var finalBlock uint16
+
+	readBlock := func(blockID uint16) (*wire.Data, error) {
+		buf := make([]byte, c.opts.BlockSize)
+		n, rerr := reader.ReadAt(buf, int64(blockID-1)*int64(c.opts.BlockSize))
+		if rerr != nil && rerr != io.EOF {
+			return nil, rerr
 		}
-		switch operation {
-		case 4: // ack packet
-			{
-				var blockID uint16
-				if err := binary.Read(buff, binary.BigEndian, &blockID); err != nil {
-					continue writeLoop
-				}
-				binary.BigEndian.PutUint16(data[0:2], uint16(0x03))
-				binary.BigEndian.PutUint16(data[2:], blockID+1)
-				n, err := reader.ReadAt(data[4:516], int64(blockID)*512)
-				if err != nil {
-					if err == io.EOF {
-						err = nil
-						break writeLoop
-					}
-					sendError(c.conn, remoteAddr, err)
+		return &wire.Data{BlockID: blockID, Data: buf[:n]}, nil
+	}
+
+	fillWindow := func() error {
+		for !haveFinal && nextBlock < sendBase+uint16(c.opts.WindowSize) {
+			// A block already in window was read before a shrink pulled
+			// nextBlock back past it: resend that copy instead of reading
+			// the same offset again.
+			dq, ok := window[nextBlock]
+			if !ok {
+				var err error
+				if dq, err = readBlock(nextBlock); err != nil {
+					c.sendErrorReq(ctx, raddr, err.Error())
 					return err
 				}
-				if _, err := c.conn.WriteTo(data[:n+4], remoteAddr); err != nil {
+			}
+			if err := c.channel.WritePacket(ctx, dq, raddr); err != nil {
+				return err
+			}
+			sent += int64(len(dq.Data))
+			c.opts.Hooks.onBlock(dq.BlockID, len(dq.Data))
+			window[nextBlock] = dq
+			if len(dq.Data) < c.opts.BlockSize {
+				haveFinal, finalBlock = true, nextBlock
+			}
+			nextBlock++
+		}
+		return nil
+	}
+
+	if err := fillWindow(); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		pkt, _, err := c.channel.ReadPacket(attemptCtx)
+		cancel()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				c.sendErrorReq(ctx, raddr, ctxErr.Error())
+				return ctxErr
+			}
+			if !isTimeout(err) {
+				return err
+			}
+			attempt++
+			if attempt > c.opts.Retries {
+				return err
+			}
+			// A timeout means the network (or the server) is struggling to
+			// keep up with the current window, so shrink it per RFC 7440
+			// before resending: halve WindowSize (floor 1), and roll
+			// nextBlock/haveFinal/finalBlock back to the end of the
+			// smaller window. Blocks past it stay in window, so fillWindow
+			// resends those same copies once we're acked back up to them.
+			if c.opts.WindowSize > 1 {
+				c.opts.WindowSize /= 2
+				if c.opts.WindowSize < 1 {
+					c.opts.WindowSize = 1
+				}
+			}
+			if shrunkTo := sendBase + uint16(c.opts.WindowSize); shrunkTo < nextBlock {
+				if haveFinal && finalBlock >= shrunkTo {
+					haveFinal, finalBlock = false, 0
+				}
+				nextBlock = shrunkTo
+			}
+			for id := sendBase; id < nextBlock; id++ {
+				if err := c.channel.WritePacket(ctx, window[id], raddr); err != nil {
 					return err
 				}
 			}
-		case 5: // error packet
-			{
-				return handleError(buff)
+			continue
+		}
+		attempt = 0
+
+		ack, ok := pkt.(*wire.Ack)
+		if !ok {
+			if ep, ok := pkt.(*wire.Error); ok {
+				return &TFTPError{Code: ep.Code, Msg: ep.Msg}
 			}
+			// Not an ACK or an Error: some packet we have no use for
+			// right now, e.g. a stray retransmit from an unrelated,
+			// already-finished transfer sharing this socket. Ignore it
+			// and keep waiting rather than aborting over noise.
+			continue
+		}
+		if ack.BlockID < sendBase {
+			continue // stale ack for a block already slid past, ignore
+		}
+
+		for id := sendBase; id <= ack.BlockID; id++ {
+			delete(window, id)
+		}
+		sendBase = ack.BlockID + 1
+		if haveFinal && sendBase > finalBlock {
+			return nil
+		}
+		if err := fillWindow(); err != nil {
+			return err
 		}
 	}
-	return nil
 }
@@ -0,0 +1,403 @@
+package gotftp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	wire "github.com/eahydra/gotftp/packet"
+	"github.com/eahydra/gotftp/packet/memchannel"
+)
+
+// newTestPipe wires up a Client over one end of a memchannel Pipe and
+// hands back the other end for a test to play server on directly, so
+// these tests can drive the state machine's edge cases deterministically
+// instead of racing a real UDP socket.
+func newTestPipe(t *testing.T, opts ClientOptions) (*Client, *memchannel.Channel) {
+	t.Helper()
+	clientAddr := memchannel.Addr("client")
+	serverAddr := memchannel.Addr("server")
+	clientCh, serverCh := memchannel.NewPipe(clientAddr, serverAddr, 8)
+	t.Cleanup(func() {
+		clientCh.Close()
+		serverCh.Close()
+	})
+	if opts.Timeout <= 0 {
+		opts.Timeout = 200 * time.Millisecond
+	}
+	c := NewClientWithChannel(clientCh, serverAddr, opts)
+	return c, serverCh
+}
+
+// growingBuffer implements io.WriterAt over an in-memory byte slice, since
+// GetContext writes each DATA block at its absolute file offset rather
+// than strictly appending.
+type growingBuffer struct {
+	buf []byte
+}
+
+func (g *growingBuffer) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > int64(len(g.buf)) {
+		grown := make([]byte, end)
+		copy(grown, g.buf)
+		g.buf = grown
+	}
+	copy(g.buf[off:end], p)
+	return len(p), nil
+}
+
+// TestGetContextFallsBackToLegacyBlockSizeWithoutOACK covers the chunk1-6
+// RFC 1350 fallback: if the server answers a RRQ with DATA instead of an
+// OACK, it never actually accepted blksize, so the client must judge the
+// final block against legacyBlockSize rather than whatever it asked for.
+func TestGetContextFallsBackToLegacyBlockSizeWithoutOACK(t *testing.T) {
+	c, server := newTestPipe(t, ClientOptions{BlockSize: modernBlockSize})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	out := &growingBuffer{}
+	go func() {
+		errCh <- c.GetContext(ctx, "f.txt", out)
+	}()
+
+	if _, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(RRQ): %v", err)
+	}
+	payload := bytes.Repeat([]byte("x"), 10)
+	dq := &wire.Data{BlockID: 1, Data: payload}
+	if err := server.WritePacket(ctx, dq, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(DATA): %v", err)
+	}
+	if pkt, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(ACK): %v", err)
+	} else if ack, ok := pkt.(*wire.Ack); !ok || ack.BlockID != 1 {
+		t.Fatalf("got %#v, want ACK block 1", pkt)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if c.opts.BlockSize != legacyBlockSize {
+		t.Fatalf("BlockSize = %d, want legacyBlockSize (%d)", c.opts.BlockSize, legacyBlockSize)
+	}
+	if !bytes.Equal(out.buf, payload) {
+		t.Fatalf("wrote %q, want %q", out.buf, payload)
+	}
+}
+
+// TestGetContextIgnoresOutOfOrderData covers a DATA block arriving ahead of
+// the one the client is waiting for: it must be dropped and the last
+// in-order block re-acked, rather than written out of order or treated as
+// an error.
+func TestGetContextIgnoresOutOfOrderData(t *testing.T) {
+	c, server := newTestPipe(t, ClientOptions{BlockSize: modernBlockSize})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	out := &growingBuffer{}
+	go func() {
+		errCh <- c.GetContext(ctx, "f.txt", out)
+	}()
+
+	if _, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(RRQ): %v", err)
+	}
+
+	ahead := &wire.Data{BlockID: 2, Data: []byte("ahead")}
+	if err := server.WritePacket(ctx, ahead, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(DATA 2): %v", err)
+	}
+	if pkt, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(ack for out-of-order block): %v", err)
+	} else if ack, ok := pkt.(*wire.Ack); !ok || ack.BlockID != 0 {
+		t.Fatalf("got %#v, want ACK block 0 (re-ack of last in-order block)", pkt)
+	}
+
+	payload := []byte("actual block one")
+	real := &wire.Data{BlockID: 1, Data: payload}
+	if err := server.WritePacket(ctx, real, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(DATA 1): %v", err)
+	}
+	if pkt, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(ack 1): %v", err)
+	} else if ack, ok := pkt.(*wire.Ack); !ok || ack.BlockID != 1 {
+		t.Fatalf("got %#v, want ACK block 1", pkt)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if !bytes.Equal(out.buf, payload) {
+		t.Fatalf("wrote %q, want %q", out.buf, payload)
+	}
+}
+
+// putHandshake answers the WRQ a just-started PutContext call sent with a
+// plain ACK for block 0, getting every PutContext test past the handshake
+// to the part of the window it actually wants to exercise. It must be
+// called after PutContext is already running in its own goroutine, since
+// it blocks waiting for the WRQ.
+func putHandshake(t *testing.T, ctx context.Context, server *memchannel.Channel) {
+	t.Helper()
+	if _, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(WRQ): %v", err)
+	}
+	if err := server.WritePacket(ctx, &wire.Ack{BlockID: 0}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(ACK 0): %v", err)
+	}
+}
+
+func recvData(t *testing.T, ctx context.Context, server *memchannel.Channel) *wire.Data {
+	t.Helper()
+	pkt, _, err := server.ReadPacket(ctx)
+	if err != nil {
+		t.Fatalf("server ReadPacket(DATA): %v", err)
+	}
+	dq, ok := pkt.(*wire.Data)
+	if !ok {
+		t.Fatalf("got %#v, want *wire.Data", pkt)
+	}
+	return dq
+}
+
+// TestPutContextResumesAfterDroppedAckMidWindow covers a window wider than
+// one block where only an earlier ACK in the window ever arrives (as if
+// the ACK for a later block got dropped): the client must resume sending
+// from where that ACK left off rather than stalling or erroring.
+func TestPutContextResumesAfterDroppedAckMidWindow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	content := []byte("aaaabbbbcc") // 3 blocks of 4 bytes: "aaaa", "bbbb", "cc" (final)
+	c, server := newTestPipe(t, ClientOptions{BlockSize: 4, WindowSize: 2})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.PutContext(ctx, "f.txt", bytes.NewReader(content))
+	}()
+	putHandshake(t, ctx, server)
+
+	if dq := recvData(t, ctx, server); dq.BlockID != 1 {
+		t.Fatalf("block = %d, want 1", dq.BlockID)
+	}
+	if dq := recvData(t, ctx, server); dq.BlockID != 2 {
+		t.Fatalf("block = %d, want 2", dq.BlockID)
+	}
+
+	// Only ACK block 1, as if block 2's ACK never made it: the client
+	// should slide to sendBase=2 and fill the window with block 3 rather
+	// than waiting for an ACK that's already been and gone.
+	if err := server.WritePacket(ctx, &wire.Ack{BlockID: 1}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(ACK 1): %v", err)
+	}
+	if dq := recvData(t, ctx, server); dq.BlockID != 3 {
+		t.Fatalf("block = %d, want 3 (final)", dq.BlockID)
+	}
+	if err := server.WritePacket(ctx, &wire.Ack{BlockID: 3}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(ACK 3): %v", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("PutContext: %v", err)
+	}
+}
+
+// TestPutContextShrinksWindowOnTimeout covers RFC 7440 window shrinking: a
+// timeout with no ACK means the network or peer can't keep up with the
+// current WindowSize, so the client must halve it and fall back to
+// resending only what fits in the new, smaller window.
+func TestPutContextShrinksWindowOnTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	content := []byte("aaaabbbbccccdd") // 4 blocks of 4 bytes, last ("dd") short
+	c, server := newTestPipe(t, ClientOptions{
+		BlockSize:  4,
+		WindowSize: 4,
+		Timeout:    50 * time.Millisecond,
+		Retries:    3,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.PutContext(ctx, "f.txt", bytes.NewReader(content))
+	}()
+	putHandshake(t, ctx, server)
+
+	for want := uint16(1); want <= 4; want++ {
+		if dq := recvData(t, ctx, server); dq.BlockID != want {
+			t.Fatalf("initial window: block = %d, want %d", dq.BlockID, want)
+		}
+	}
+
+	// Let the whole window time out with no ACK at all. WindowSize should
+	// shrink from 4 to 2, so only blocks 1-2 are resent, not 3-4.
+	if dq := recvData(t, ctx, server); dq.BlockID != 1 {
+		t.Fatalf("after timeout: block = %d, want 1", dq.BlockID)
+	}
+	if dq := recvData(t, ctx, server); dq.BlockID != 2 {
+		t.Fatalf("after timeout: block = %d, want 2", dq.BlockID)
+	}
+	if c.opts.WindowSize != 2 {
+		t.Fatalf("WindowSize = %d, want 2 after one timeout", c.opts.WindowSize)
+	}
+
+	if err := server.WritePacket(ctx, &wire.Ack{BlockID: 2}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(ACK 2): %v", err)
+	}
+	if dq := recvData(t, ctx, server); dq.BlockID != 3 {
+		t.Fatalf("block = %d, want 3", dq.BlockID)
+	}
+	if dq := recvData(t, ctx, server); dq.BlockID != 4 {
+		t.Fatalf("block = %d, want 4 (final)", dq.BlockID)
+	}
+	if err := server.WritePacket(ctx, &wire.Ack{BlockID: 4}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(ACK 4): %v", err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("PutContext: %v", err)
+	}
+}
+
+// fakeGetServer plays a minimal RRQ server on server: it OACKs whatever
+// options it was sent, then streams content out in blksize-sized blocks,
+// waiting for each ACK before moving on. It's a stand-in for a real
+// clientPeer, which the Channel split makes possible without ever binding
+// a socket.
+func fakeGetServer(t *testing.T, ctx context.Context, server *memchannel.Channel, content []byte, blockSize int) {
+	t.Helper()
+	pkt, _, err := server.ReadPacket(ctx)
+	if err != nil {
+		t.Fatalf("server ReadPacket(RRQ): %v", err)
+	}
+	rrq, ok := pkt.(*wire.RRQ)
+	if !ok {
+		t.Fatalf("got %#v, want *wire.RRQ", pkt)
+	}
+	if err := server.WritePacket(ctx, &wire.OAck{Options: rrq.Options}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(OACK): %v", err)
+	}
+	if pkt, _, err := server.ReadPacket(ctx); err != nil {
+		t.Fatalf("server ReadPacket(ACK 0): %v", err)
+	} else if ack, ok := pkt.(*wire.Ack); !ok || ack.BlockID != 0 {
+		t.Fatalf("got %#v, want ACK block 0", pkt)
+	}
+
+	var blockID uint16 = 1
+	for off := 0; ; off += blockSize {
+		end := off + blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		dq := &wire.Data{BlockID: blockID, Data: content[off:end]}
+		if err := server.WritePacket(ctx, dq, memchannel.Addr("client")); err != nil {
+			t.Fatalf("server WritePacket(DATA %d): %v", blockID, err)
+		}
+		if pkt, _, err := server.ReadPacket(ctx); err != nil {
+			t.Fatalf("server ReadPacket(ACK %d): %v", blockID, err)
+		} else if ack, ok := pkt.(*wire.Ack); !ok || ack.BlockID != blockID {
+			t.Fatalf("got %#v, want ACK block %d", pkt, blockID)
+		}
+		if end-off < blockSize {
+			return
+		}
+		blockID++
+	}
+}
+
+// TestGetContextMultiBlockRoundTripOverChannel proves the Channel split
+// (chunk0-6) actually buys what it promised: a multi-block, OACK-
+// negotiated transfer driven entirely over an in-memory Channel, with no
+// real socket anywhere in the test.
+func TestGetContextMultiBlockRoundTripOverChannel(t *testing.T) {
+	// Timeout must be a whole number of seconds: requestPacket encodes it
+	// as a "timeout" option in whole seconds, and the fake server below
+	// echoes it back verbatim in the OACK, same as a real one would.
+	c, server := newTestPipe(t, ClientOptions{BlockSize: 4, Timeout: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	content := []byte("aaaabbbbccccdd")
+	errCh := make(chan error, 1)
+	out := &growingBuffer{}
+	go func() {
+		errCh <- c.GetContext(ctx, "f.txt", out)
+	}()
+
+	fakeGetServer(t, ctx, server, content, 4)
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if !bytes.Equal(out.buf, content) {
+		t.Fatalf("wrote %q, want %q", out.buf, content)
+	}
+}
+
+// fakePutServer is fakeGetServer's WRQ counterpart: it OACKs, then reads
+// blocks until one comes in short, acking each as it goes.
+func fakePutServer(t *testing.T, ctx context.Context, server *memchannel.Channel, blockSize int) []byte {
+	t.Helper()
+	pkt, _, err := server.ReadPacket(ctx)
+	if err != nil {
+		t.Fatalf("server ReadPacket(WRQ): %v", err)
+	}
+	wrq, ok := pkt.(*wire.WRQ)
+	if !ok {
+		t.Fatalf("got %#v, want *wire.WRQ", pkt)
+	}
+	if err := server.WritePacket(ctx, &wire.OAck{Options: wrq.Options}, memchannel.Addr("client")); err != nil {
+		t.Fatalf("server WritePacket(OACK): %v", err)
+	}
+
+	var received []byte
+	for {
+		pkt, _, err := server.ReadPacket(ctx)
+		if err != nil {
+			t.Fatalf("server ReadPacket(DATA): %v", err)
+		}
+		dq, ok := pkt.(*wire.Data)
+		if !ok {
+			t.Fatalf("got %#v, want *wire.Data", pkt)
+		}
+		received = append(received, dq.Data...)
+		if err := server.WritePacket(ctx, &wire.Ack{BlockID: dq.BlockID}, memchannel.Addr("client")); err != nil {
+			t.Fatalf("server WritePacket(ACK %d): %v", dq.BlockID, err)
+		}
+		if len(dq.Data) < blockSize {
+			return received
+		}
+	}
+}
+
+// TestPutContextMultiBlockRoundTripOverChannel is
+// TestGetContextMultiBlockRoundTripOverChannel's WRQ counterpart.
+func TestPutContextMultiBlockRoundTripOverChannel(t *testing.T) {
+	c, server := newTestPipe(t, ClientOptions{BlockSize: 4, Timeout: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	content := []byte("aaaabbbbccccdd")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.PutContext(ctx, "f.txt", bytes.NewReader(content))
+	}()
+
+	received := fakePutServer(t, ctx, server, 4)
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("PutContext: %v", err)
+	}
+	if !bytes.Equal(received, content) {
+		t.Fatalf("received %q, want %q", received, content)
+	}
+}
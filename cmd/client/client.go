@@ -29,7 +29,7 @@ func main() {
 		fmt.Println("invalid command, please set remote address")
 		return
 	}
-	client, err := gotftp.NewClient(addr, time.Duration(3)*time.Second, 3)
+	client, err := gotftp.NewClient(addr, gotftp.ClientOptions{Timeout: time.Duration(3) * time.Second, Retries: 3})
 	if err != nil {
 		fmt.Println("err:", err)
 		return
@@ -0,0 +1,40 @@
+package gotftp
+
+import "errors"
+
+// TFTP error codes, as assigned by RFC 1350 section 5 (1-7) and extended by
+// RFC 2347 (8) for option negotiation failures.
+const (
+	ErrFileNotFound     uint16 = 1
+	ErrAccessViolation  uint16 = 2
+	ErrDiskFull         uint16 = 3
+	ErrIllegalOperation uint16 = 4
+	ErrUnknownTID       uint16 = 5
+	ErrFileExists       uint16 = 6
+	ErrNoSuchUser       uint16 = 7
+	ErrBadOptions       uint16 = 8
+
+	// ErrBusy is a non-standard, Fuchsia-style extension: it tells a client
+	// "I recognize this request but can't service it right now", as
+	// opposed to every other code, which is terminal. Client recognizes it
+	// specifically and retries the RRQ/WRQ with exponential backoff instead
+	// of surfacing it as a failure.
+	ErrBusy uint16 = 0x143
+)
+
+// TFTPError is the error type a FileHandler returns to control exactly
+// which TFTP error code is sent back on the wire, instead of every failure
+// collapsing to code 0 ("not defined").
+type TFTPError struct {
+	Code uint16
+	Msg  string
+}
+
+func (e *TFTPError) Error() string {
+	return e.Msg
+}
+
+// errInvalidReq is returned when a peer sends something the state machine
+// never expects in reply (e.g. a WRQ's ACK for the wrong block), and by a
+// CachingFileHandler reader's Seek for an unknown whence.
+var errInvalidReq = errors.New("gotftp: invalid request")
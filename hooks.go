@@ -0,0 +1,65 @@
+package gotftp
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Op identifies which half of a transfer a Hooks callback is about.
+type Op int
+
+const (
+	OpRead  Op = iota // RRQ: server sends, client receives
+	OpWrite           // WRQ: client sends, server receives
+)
+
+func (op Op) String() string {
+	if op == OpWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// Hooks lets a caller observe transfers without forking the package, e.g.
+// to emit Prometheus counters for bytes/sec, retries, or peer count. Every
+// field is optional; a nil callback is simply skipped.
+type Hooks struct {
+	// OnTransferStart fires once a RRQ/WRQ has been accepted and the
+	// underlying file is open (server side) or the RRQ/WRQ has been sent
+	// (client side).
+	OnTransferStart func(remoteAddr, filename string, op Op)
+	// OnTransferEnd fires exactly once per transfer that called
+	// OnTransferStart, successfully or not; err is nil on success.
+	OnTransferEnd func(remoteAddr, filename string, op Op, bytes int64, dur time.Duration, err error)
+	// OnBlock fires for each DATA block the side sending the file (the
+	// server on RRQ, the client on WRQ) sends, and for each block the
+	// receiving side writes in order. A block that is sent again may fire
+	// it again.
+	OnBlock func(blockID uint16, size int)
+}
+
+func (h Hooks) onTransferStart(remoteAddr, filename string, op Op) {
+	if h.OnTransferStart != nil {
+		h.OnTransferStart(remoteAddr, filename, op)
+	}
+}
+
+func (h Hooks) onTransferEnd(remoteAddr, filename string, op Op, bytes int64, dur time.Duration, err error) {
+	if h.OnTransferEnd != nil {
+		h.OnTransferEnd(remoteAddr, filename, op, bytes, dur, err)
+	}
+}
+
+func (h Hooks) onBlock(blockID uint16, size int) {
+	if h.OnBlock != nil {
+		h.OnBlock(blockID, size)
+	}
+}
+
+// discardLogger is what Server and Client fall back to when no Logger is
+// configured, so every call site can log through it unconditionally
+// instead of nil-checking first.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -23,12 +23,12 @@ func EnableVerbose(enable bool) {
 
 var defaultLog = log.New(os.Stdout, "gotftp ", log.LstdFlags|log.Lmicroseconds)
 var defaultLogHandler = func(s string) {
-	defaultLog.Printf(s)
+	defaultLog.Print(s)
 }
 
 func logln(v ...interface{}) {
 	if verboseMode {
-		defaultLogHandler(fmt.Sprintln(v))
+		defaultLogHandler(fmt.Sprintln(v...))
 	}
 }
 
@@ -0,0 +1,103 @@
+package gotftp
+
+import "io"
+
+// netasciiReader wraps a byte-oriented Reader and translates its bytes to
+// netascii as RFC 764 requires: LF becomes CR LF, and a bare CR becomes
+// CR NUL. One input byte can turn into two output bytes, which is why this
+// can't just be handed p.blockSize bytes at a time from the file directly.
+type netasciiReader struct {
+	r           io.Reader
+	pending     byte
+	havePending bool
+}
+
+func newNetasciiReader(r io.Reader) *netasciiReader {
+	return &netasciiReader{r: r}
+}
+
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	written := 0
+	var b [1]byte
+	for written < len(p) {
+		if n.havePending {
+			p[written] = n.pending
+			n.havePending = false
+			written++
+			continue
+		}
+		nr, err := n.r.Read(b[:])
+		if nr == 0 {
+			if written > 0 {
+				return written, nil
+			}
+			return 0, err
+		}
+		switch b[0] {
+		case '\n':
+			p[written] = '\r'
+			n.pending, n.havePending = '\n', true
+		case '\r':
+			p[written] = '\r'
+			n.pending, n.havePending = 0, true
+		default:
+			p[written] = b[0]
+		}
+		written++
+	}
+	return written, nil
+}
+
+// netasciiWriter wraps a byte-oriented Writer and reverses netasciiReader's
+// translation: CR LF becomes LF, and CR NUL becomes a bare CR. A CR that
+// arrives as the last byte of a block is held back until the next Write
+// (or Flush, at end of transfer) reveals what follows it.
+type netasciiWriter struct {
+	w     io.Writer
+	sawCR bool
+}
+
+func newNetasciiWriter(w io.Writer) *netasciiWriter {
+	return &netasciiWriter{w: w}
+}
+
+func (n *netasciiWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if n.sawCR {
+			n.sawCR = false
+			switch b {
+			case '\n':
+				out = append(out, '\n')
+			case 0:
+				out = append(out, '\r')
+			default:
+				// Not valid netascii (CR not followed by LF or NUL); pass both through untranslated.
+				out = append(out, '\r', b)
+			}
+			continue
+		}
+		if b == '\r' {
+			n.sawCR = true
+			continue
+		}
+		out = append(out, b)
+	}
+	if len(out) > 0 {
+		if _, err := n.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out a CR left pending at the end of the transfer instead of
+// waiting forever for a byte that will never arrive.
+func (n *netasciiWriter) Flush() error {
+	if !n.sawCR {
+		return nil
+	}
+	n.sawCR = false
+	_, err := n.w.Write([]byte{'\r'})
+	return err
+}
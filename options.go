@@ -0,0 +1,110 @@
+package gotftp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Options is a convenience bundle of the handful of negotiable knobs
+// ReadFileWithOptions/WriteFileWithOptions expose, for callers that want
+// RFC 2347/2348/2349 option negotiation without building a Client and
+// ClientOptions themselves.
+type Options struct {
+	BlockSize    int           // RFC 2348 blksize, 8..65464; 0 uses the package default
+	WindowSize   int           // RFC 7440 windowsize, 1..65535; 0 uses the package default
+	Timeout      time.Duration // per-packet ACK/DATA wait before retrying; 0 uses the package default
+	TransferSize int64         // WRQ only: size of what WriteFileWithOptions is about to send, announced as tsize
+}
+
+// ReadFileWithOptions fetches filename from addr into w, negotiating opts
+// instead of settling for the package defaults. The server's OACK (if
+// any) is accepted transparently; a server that answers with DATA
+// straight away, with no option support at all, works too.
+func ReadFileWithOptions(addr, filename string, w io.Writer, opts Options) error {
+	client, err := NewClient(addr, ClientOptions{BlockSize: opts.BlockSize, WindowSize: opts.WindowSize, Timeout: opts.Timeout})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Get(filename, &sequentialWriterAt{w: w})
+}
+
+// WriteFileWithOptions sends r to addr as filename, negotiating opts. If
+// opts.TransferSize is set, it's announced to the server as tsize so it
+// can learn the file size up front rather than only at the short final
+// block.
+func WriteFileWithOptions(addr, filename string, r io.Reader, opts Options) error {
+	client, err := NewClient(addr, ClientOptions{BlockSize: opts.BlockSize, WindowSize: opts.WindowSize, Timeout: opts.Timeout})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	client.SetTransferSize(opts.TransferSize)
+	return client.Put(filename, &sequentialReaderAt{r: r})
+}
+
+// ReadFileContext is ReadFileWithOptions, but aborts the transfer as soon
+// as ctx is done: the client sends the peer a proper ERROR packet instead
+// of just walking away, then returns ctx.Err().
+func ReadFileContext(ctx context.Context, addr, filename string, w io.Writer, opts Options) error {
+	client, err := NewClient(addr, ClientOptions{BlockSize: opts.BlockSize, WindowSize: opts.WindowSize, Timeout: opts.Timeout})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.GetContext(ctx, filename, &sequentialWriterAt{w: w})
+}
+
+// WriteFileContext is WriteFileWithOptions, but aborts the transfer as
+// soon as ctx is done: the client sends the peer a proper ERROR packet
+// instead of just walking away, then returns ctx.Err().
+func WriteFileContext(ctx context.Context, addr, filename string, r io.Reader, opts Options) error {
+	client, err := NewClient(addr, ClientOptions{BlockSize: opts.BlockSize, WindowSize: opts.WindowSize, Timeout: opts.Timeout})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	client.SetTransferSize(opts.TransferSize)
+	return client.PutContext(ctx, filename, &sequentialReaderAt{r: r})
+}
+
+var errNonSequentialIO = errors.New("gotftp: options helper only supports sequential reads/writes")
+
+// sequentialWriterAt adapts an io.Writer to io.WriterAt for Client.Get,
+// which only ever writes in block order (even with windowsize > 1, it
+// drops out-of-order DATA rather than buffering it), so the offsets
+// WriteAt sees always advance one write at a time.
+type sequentialWriterAt struct {
+	w      io.Writer
+	offset int64
+}
+
+func (s *sequentialWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if off != s.offset {
+		return 0, errNonSequentialIO
+	}
+	n, err := s.w.Write(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+// sequentialReaderAt adapts an io.Reader to io.ReaderAt for Client.Put,
+// which only ever reads the next block once, in order, to fill its
+// window (a resend on timeout replays the buffered DATA it already
+// built, not a fresh ReadAt), so the offsets ReadAt sees always advance
+// one read at a time.
+type sequentialReaderAt struct {
+	r      io.Reader
+	offset int64
+}
+
+func (s *sequentialReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off != s.offset {
+		return 0, errNonSequentialIO
+	}
+	n, err := s.r.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
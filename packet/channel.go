@@ -0,0 +1,76 @@
+package packet
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Channel is the transport a clientPeer drives the TFTP state machine
+// over. Abstracting it away from the state machine means the state
+// machine can run against an in-memory Channel in tests, or over some
+// other transport (a length-prefixed TCP tunnel, say) in production,
+// without caring which.
+type Channel interface {
+	// ReadPacket blocks for the next datagram, honoring ctx's deadline,
+	// and decodes it. The returned net.Addr is valid even when err is a
+	// decode error (as opposed to a transport error), so callers can
+	// still tell who sent the bad packet.
+	ReadPacket(ctx context.Context) (Packet, net.Addr, error)
+	// WritePacket encodes p and sends it to addr.
+	WritePacket(ctx context.Context, p Packet, addr net.Addr) error
+}
+
+// UDPChannel implements Channel over a net.PacketConn, which is how every
+// TFTP peer actually talks on the wire per RFC 1350. It encodes/decodes
+// through a Codec, defaulting to DefaultCodec (the standard TFTP wire
+// format) when none is given.
+type UDPChannel struct {
+	conn  net.PacketConn
+	codec Codec
+}
+
+// NewUDPChannel wraps conn as a Channel using the standard TFTP wire codec.
+func NewUDPChannel(conn net.PacketConn) *UDPChannel {
+	return NewUDPChannelWithCodec(conn, DefaultCodec)
+}
+
+// NewUDPChannelWithCodec wraps conn as a Channel, encoding/decoding with
+// codec instead of the standard TFTP wire format.
+func NewUDPChannelWithCodec(conn net.PacketConn, codec Codec) *UDPChannel {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	return &UDPChannel{conn: conn, codec: codec}
+}
+
+// ReadPacket implements Channel.
+func (c *UDPChannel) ReadPacket(ctx context.Context) (Packet, net.Addr, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetReadDeadline(deadline)
+	} else {
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	buf := make([]byte, 65536)
+	n, addr, err := c.conn.ReadFrom(buf)
+	if err != nil {
+		return nil, addr, err
+	}
+	p, err := c.codec.Decode(buf[:n])
+	return p, addr, err
+}
+
+// WritePacket implements Channel.
+func (c *UDPChannel) WritePacket(ctx context.Context, p Packet, addr net.Addr) error {
+	data, err := c.codec.Encode(p)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.WriteTo(data, addr)
+	return err
+}
+
+// Close closes the underlying connection.
+func (c *UDPChannel) Close() error {
+	return c.conn.Close()
+}
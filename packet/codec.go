@@ -0,0 +1,21 @@
+package packet
+
+// Codec encodes and decodes Packets to and from wire bytes. It is kept
+// separate from Channel so a transport can be reused with a different wire
+// format (or a wire format reused over a different transport) without
+// either side knowing about the other.
+type Codec interface {
+	Encode(p Packet) ([]byte, error)
+	Decode(data []byte) (Packet, error)
+}
+
+// defaultCodec is the standard RFC 1350/2347/2348/2349/7440 wire encoding
+// implemented by Marshal/Unmarshal.
+type defaultCodec struct{}
+
+func (defaultCodec) Encode(p Packet) ([]byte, error)    { return Marshal(p) }
+func (defaultCodec) Decode(data []byte) (Packet, error) { return Unmarshal(data) }
+
+// DefaultCodec is the Codec every Channel in this package falls back to
+// when none is supplied.
+var DefaultCodec Codec = defaultCodec{}
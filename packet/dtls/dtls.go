@@ -0,0 +1,87 @@
+// Package dtls adapts an already-established secure, stream-framed
+// connection into a packet.Channel, for TFTP transfers that need
+// confidentiality in transit — firmware delivery being the canonical case.
+// This package does not perform a DTLS handshake itself: wire up whichever
+// DTLS implementation you trust (e.g. pion/dtls) to produce a net.Conn,
+// then wrap it here.
+package dtls
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/eahydra/gotftp/packet"
+)
+
+// Channel implements packet.Channel over a single secure net.Conn. Unlike
+// UDPChannel, which reads one datagram per packet off a PacketConn, conn
+// here is a byte stream (what every DTLS library in the Go ecosystem
+// exposes), so each packet is framed with a 2-byte big-endian length
+// prefix ahead of its encoded bytes.
+type Channel struct {
+	conn  net.Conn
+	codec packet.Codec
+}
+
+// New wraps an already-secured conn (post DTLS handshake) as a
+// packet.Channel, encoding/decoding with codec. A nil codec falls back to
+// packet.DefaultCodec.
+func New(conn net.Conn, codec packet.Codec) *Channel {
+	if codec == nil {
+		codec = packet.DefaultCodec
+	}
+	return &Channel{conn: conn, codec: codec}
+}
+
+// ReadPacket implements packet.Channel.
+func (c *Channel) ReadPacket(ctx context.Context) (packet.Packet, net.Addr, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetReadDeadline(deadline)
+	} else {
+		c.conn.SetReadDeadline(time.Time{})
+	}
+
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(c.conn, lenBuf[:]); err != nil {
+		return nil, c.conn.RemoteAddr(), err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return nil, c.conn.RemoteAddr(), err
+	}
+	p, err := c.codec.Decode(data)
+	return p, c.conn.RemoteAddr(), err
+}
+
+// WritePacket implements packet.Channel. addr is ignored: a Channel wraps
+// exactly one secure connection to exactly one peer, so there's nowhere
+// else it could go.
+func (c *Channel) WritePacket(ctx context.Context, p packet.Packet, addr net.Addr) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	}
+
+	data, err := c.codec.Encode(p)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0xffff {
+		return fmt.Errorf("dtls: packet too large to frame: %d bytes", len(data))
+	}
+	var lenBuf [2]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(data)))
+	if _, err := c.conn.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// Close closes the underlying connection.
+func (c *Channel) Close() error {
+	return c.conn.Close()
+}
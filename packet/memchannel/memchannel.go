@@ -0,0 +1,90 @@
+// Package memchannel provides an in-memory packet.Channel pair, so tests
+// that exercise the TFTP state machine don't need to bind real UDP sockets
+// (and the flakiness/ordering surprises that come with them) to do it.
+package memchannel
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/eahydra/gotftp/packet"
+)
+
+// Addr identifies one end of a Pipe. It satisfies net.Addr so a Channel
+// built on top of a Pipe can be handed straight to code that only knows
+// about net.Addr.
+type Addr string
+
+func (a Addr) Network() string { return "memchannel" }
+func (a Addr) String() string  { return string(a) }
+
+type datagram struct {
+	pkt  packet.Packet
+	from Addr
+}
+
+// Channel is one end of an in-memory, in-process Pipe. It implements
+// packet.Channel by shuttling already-decoded Packets over a Go channel,
+// so there's no wire encoding involved at all.
+type Channel struct {
+	local  Addr
+	remote Addr
+	send   chan<- datagram
+	recv   <-chan datagram
+}
+
+// NewPipe returns two connected Channels: writes to one arrive as reads on
+// the other. localAddr/remoteAddr are the addresses each side reports
+// itself and its peer as, e.g. for logging or TID checks.
+func NewPipe(localAddr, remoteAddr Addr, bufferSize int) (*Channel, *Channel) {
+	ab := make(chan datagram, bufferSize)
+	ba := make(chan datagram, bufferSize)
+	a := &Channel{local: localAddr, remote: remoteAddr, send: ab, recv: ba}
+	b := &Channel{local: remoteAddr, remote: localAddr, send: ba, recv: ab}
+	return a, b
+}
+
+// ReadPacket implements packet.Channel.
+func (c *Channel) ReadPacket(ctx context.Context) (packet.Packet, net.Addr, error) {
+	select {
+	case dg, ok := <-c.recv:
+		if !ok {
+			return nil, c.remote, fmt.Errorf("memchannel: closed")
+		}
+		return dg.pkt, dg.from, nil
+	case <-ctx.Done():
+		return nil, c.remote, ctx.Err()
+	}
+}
+
+// WritePacket implements packet.Channel. addr is ignored beyond being
+// echoed back to the peer's ReadPacket, since a Pipe only ever has the one
+// other end.
+//
+// Unlike a real Channel, which always hands ReadPacket a freshly decoded
+// Packet, a memchannel Pipe would otherwise let sender and receiver share
+// the same Data.Data slice. A sender that recycles its send buffers (e.g.
+// peer.go's per-peer sync.Pool) could then mutate an already-queued
+// packet before the receiver ever reads it, so Data packets are copied
+// here the same way wire encoding would implicitly copy them.
+func (c *Channel) WritePacket(ctx context.Context, p packet.Packet, addr net.Addr) error {
+	if d, ok := p.(*packet.Data); ok {
+		cp := *d
+		cp.Data = append([]byte(nil), d.Data...)
+		p = &cp
+	}
+	select {
+	case c.send <- datagram{pkt: p, from: c.local}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close releases this end's send side. The peer's next ReadPacket past any
+// already-queued datagrams fails with an error rather than blocking forever.
+func (c *Channel) Close() error {
+	close(c.send)
+	return nil
+}
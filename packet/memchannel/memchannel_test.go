@@ -0,0 +1,87 @@
+package memchannel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eahydra/gotftp/packet"
+)
+
+func TestPipeRoundTrip(t *testing.T) {
+	a, b := NewPipe("client", "server", 4)
+	defer a.Close()
+	defer b.Close()
+
+	ack := &packet.Ack{BlockID: 7}
+	if err := a.WritePacket(context.Background(), ack, Addr("server")); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	pkt, from, err := b.ReadPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if from.String() != "client" {
+		t.Fatalf("from = %q, want %q", from.String(), "client")
+	}
+	got, ok := pkt.(*packet.Ack)
+	if !ok || got.BlockID != 7 {
+		t.Fatalf("got %#v, want *packet.Ack{BlockID: 7}", pkt)
+	}
+}
+
+func TestReadPacketHonorsContext(t *testing.T) {
+	a, b := NewPipe("client", "server", 1)
+	defer a.Close()
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := a.ReadPacket(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// TestWritePacketCopiesDataPayload covers a sender that recycles its DATA
+// buffers (e.g. a sync.Pool): mutating the buffer right after WritePacket
+// returns must never change what the peer later reads.
+func TestWritePacketCopiesDataPayload(t *testing.T) {
+	a, b := NewPipe("client", "server", 4)
+	defer a.Close()
+	defer b.Close()
+
+	buf := []byte{1, 2, 3}
+	if err := a.WritePacket(context.Background(), &packet.Data{BlockID: 1, Data: buf}, Addr("server")); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	buf[0] = 0xff // simulate the sender reusing/overwriting its send buffer
+
+	pkt, _, err := b.ReadPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	got, ok := pkt.(*packet.Data)
+	if !ok {
+		t.Fatalf("got %T, want *packet.Data", pkt)
+	}
+	if got.Data[0] != 1 {
+		t.Fatalf("Data[0] = %d, want 1 (WritePacket should have copied the payload)", got.Data[0])
+	}
+}
+
+func TestCloseUnblocksPeerRead(t *testing.T) {
+	a, b := NewPipe("client", "server", 1)
+	defer b.Close()
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, _, err := b.ReadPacket(ctx); err == nil {
+		t.Fatal("ReadPacket after peer Close: got nil error, want one")
+	}
+}
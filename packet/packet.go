@@ -0,0 +1,215 @@
+// Package packet is the wire codec for TFTP (RFC 1350, plus the RFC 2347
+// option extensions and RFC 7440 windowsize): typed request/response values
+// and Marshal/Unmarshal between them and the bytes that actually go out on
+// the socket. Keeping this separate from the clientPeer state machine lets
+// the state machine be driven by any Channel, not just a real net.PacketConn.
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Opcodes, per RFC 1350 section 5 and RFC 2347 section 3 (OAck).
+const (
+	OpRRQ   uint16 = 1
+	OpWRQ   uint16 = 2
+	OpData  uint16 = 3
+	OpAck   uint16 = 4
+	OpError uint16 = 5
+	OpOAck  uint16 = 6
+)
+
+// Packet is any value that can be marshaled onto the wire as a single TFTP
+// datagram.
+type Packet interface {
+	Opcode() uint16
+}
+
+// Option is a single RFC 2347 "name\0value\0" pair, kept in the order it
+// appeared on the wire since an OAck must echo back options in a stable
+// order.
+type Option struct {
+	Name  string
+	Value string
+}
+
+// RRQ is a read request.
+type RRQ struct {
+	FileName string
+	Mode     string
+	Options  []Option
+}
+
+// Opcode implements Packet.
+func (*RRQ) Opcode() uint16 { return OpRRQ }
+
+// WRQ is a write request.
+type WRQ struct {
+	FileName string
+	Mode     string
+	Options  []Option
+}
+
+// Opcode implements Packet.
+func (*WRQ) Opcode() uint16 { return OpWRQ }
+
+// Data carries one block of file data.
+type Data struct {
+	BlockID uint16
+	Data    []byte
+}
+
+// Opcode implements Packet.
+func (*Data) Opcode() uint16 { return OpData }
+
+// Ack acknowledges BlockID.
+type Ack struct {
+	BlockID uint16
+}
+
+// Opcode implements Packet.
+func (*Ack) Opcode() uint16 { return OpAck }
+
+// Error aborts a transfer with a TFTP error code and a human-readable
+// message.
+type Error struct {
+	Code uint16
+	Msg  string
+}
+
+// Opcode implements Packet.
+func (*Error) Opcode() uint16 { return OpError }
+
+// OAck confirms the options the server actually accepted, which may be a
+// subset of (or smaller than) what the client asked for.
+type OAck struct {
+	Options []Option
+}
+
+// Opcode implements Packet.
+func (*OAck) Opcode() uint16 { return OpOAck }
+
+// Marshal encodes p as the bytes of a single TFTP datagram.
+func Marshal(p Packet) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := binary.Write(buf, binary.BigEndian, p.Opcode()); err != nil {
+		return nil, err
+	}
+	switch t := p.(type) {
+	case *RRQ:
+		writeCString(buf, t.FileName)
+		writeCString(buf, t.Mode)
+		writeOptions(buf, t.Options)
+	case *WRQ:
+		writeCString(buf, t.FileName)
+		writeCString(buf, t.Mode)
+		writeOptions(buf, t.Options)
+	case *Data:
+		binary.Write(buf, binary.BigEndian, t.BlockID)
+		buf.Write(t.Data)
+	case *Ack:
+		binary.Write(buf, binary.BigEndian, t.BlockID)
+	case *Error:
+		binary.Write(buf, binary.BigEndian, t.Code)
+		writeCString(buf, t.Msg)
+	case *OAck:
+		writeOptions(buf, t.Options)
+	default:
+		return nil, fmt.Errorf("packet: unsupported packet type %T", p)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the bytes of a single TFTP datagram into the concrete
+// Packet it represents.
+func Unmarshal(data []byte) (Packet, error) {
+	buf := bytes.NewBuffer(data)
+	var opcode uint16
+	if err := binary.Read(buf, binary.BigEndian, &opcode); err != nil {
+		return nil, err
+	}
+	switch opcode {
+	case OpRRQ, OpWRQ:
+		fileName, err := readCString(buf)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := readCString(buf)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := readOptions(buf)
+		if err != nil {
+			return nil, err
+		}
+		if opcode == OpRRQ {
+			return &RRQ{FileName: fileName, Mode: mode, Options: opts}, nil
+		}
+		return &WRQ{FileName: fileName, Mode: mode, Options: opts}, nil
+	case OpData:
+		var blockID uint16
+		if err := binary.Read(buf, binary.BigEndian, &blockID); err != nil {
+			return nil, err
+		}
+		return &Data{BlockID: blockID, Data: buf.Bytes()}, nil
+	case OpAck:
+		var blockID uint16
+		if err := binary.Read(buf, binary.BigEndian, &blockID); err != nil {
+			return nil, err
+		}
+		return &Ack{BlockID: blockID}, nil
+	case OpError:
+		var code uint16
+		if err := binary.Read(buf, binary.BigEndian, &code); err != nil {
+			return nil, err
+		}
+		msg, _ := readCString(buf)
+		return &Error{Code: code, Msg: msg}, nil
+	case OpOAck:
+		opts, err := readOptions(buf)
+		if err != nil {
+			return nil, err
+		}
+		return &OAck{Options: opts}, nil
+	default:
+		return nil, fmt.Errorf("packet: unknown opcode %d", opcode)
+	}
+}
+
+func writeCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.WriteByte(0)
+}
+
+func writeOptions(buf *bytes.Buffer, opts []Option) {
+	for _, opt := range opts {
+		writeCString(buf, opt.Name)
+		writeCString(buf, opt.Value)
+	}
+}
+
+func readCString(buf *bytes.Buffer) (string, error) {
+	s, err := buf.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return s[:len(s)-1], nil
+}
+
+func readOptions(buf *bytes.Buffer) ([]Option, error) {
+	var opts []Option
+	for buf.Len() > 0 {
+		name, err := readCString(buf)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readCString(buf)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Option{Name: name, Value: value})
+	}
+	return opts, nil
+}
@@ -0,0 +1,708 @@
+package gotftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wire "github.com/eahydra/gotftp/packet"
+)
+
+const defaultMaxRetries = 5
+
+const (
+	// legacyBlockSize is what a transfer uses when the request carries no
+	// options at all: with no OACK round-trip to tell a plain RFC 1350
+	// client otherwise, the block size can't move off the spec default.
+	legacyBlockSize = 512
+	// modernBlockSize is what a transfer defaults to when the request
+	// shows option support (it carries some option) but doesn't name
+	// blksize explicitly, rather than quietly leaving it at legacyBlockSize.
+	modernBlockSize = 1024
+	// defaultBlockSizeCeiling keeps a negotiated blksize under a typical
+	// 1500-byte Ethernet MTU minus IP/UDP/TFTP headers, so accepting it
+	// verbatim doesn't invite silent IP fragmentation.
+	defaultBlockSizeCeiling = 1468
+)
+
+func fileNotFoundError() *wire.Error {
+	return &wire.Error{Code: ErrFileNotFound, Msg: "file not found"}
+}
+func illegalOperationError() *wire.Error {
+	return &wire.Error{Code: ErrIllegalOperation, Msg: "illegal tftp operation"}
+}
+func unknownTIDError() *wire.Error {
+	return &wire.Error{Code: ErrUnknownTID, Msg: "unknown transfer id"}
+}
+func fileExistsError() *wire.Error {
+	return &wire.Error{Code: ErrFileExists, Msg: "file already exists"}
+}
+
+type clientPeer struct {
+	remoteAddr       net.Addr
+	conn             net.PacketConn
+	channel          wire.Channel
+	keepaliveTime    time.Time
+	blockSize        int
+	transferSize     int
+	timeout          int
+	windowSize       int
+	maxRetries       int
+	retries          int
+	blockSizeCeiling int
+	mode             string      // "octet" or "netascii"
+	lastSent         wire.Packet // last DATA/OACK/ACK sent, resent verbatim on timeout
+	sendPool         *sync.Pool  // recycles DATA payload buffers across a transfer
+	sendBufSize      int
+	sendBase         uint16 // lowest block sent but not yet acked (read/RRQ side)
+	recvHighest      uint16 // highest contiguously written block (write/WRQ side)
+	fileHandler      FileHandler
+	readSeekCloser   ReadSeekCloser
+	writeSeekCloser  WriteSeekCloser
+	netReader        *netasciiReader // set when mode == "netascii", RRQ side
+	netBlockCursor   uint16          // next block netReader is positioned to emit
+	netWriter        *netasciiWriter // set when mode == "netascii", WRQ side
+
+	logger *slog.Logger
+	hooks  Hooks
+
+	// fileName/op/startTime/transferredBytes/started/ended back
+	// beginTransfer/endTransfer, which fire hooks.OnTransferStart/End
+	// exactly once per transfer.
+	fileName         string
+	op               Op
+	startTime        time.Time
+	transferredBytes int64
+	started          bool
+	ended            bool
+}
+
+// write sends pkt to the peer over its channel and remembers it as the
+// thing to retransmit verbatim if no response shows up before the next
+// timeout. If the packet it supersedes was a DATA packet drawn from
+// sendPool, that buffer is released now that it's no longer needed.
+func (p *clientPeer) write(pkt wire.Packet) {
+	if d, ok := p.lastSent.(*wire.Data); ok && p.sendPool != nil {
+		p.sendPool.Put(d.Data[:cap(d.Data)])
+	}
+	p.lastSent = pkt
+	p.channel.WritePacket(context.Background(), pkt, p.remoteAddr)
+}
+
+// sendError reports err to the peer as a TFTP error packet. A FileHandler
+// that wants a specific error code on the wire (e.g. ErrBusy) should return
+// a *TFTPError; anything else is reported as code 0, "not defined".
+func (p *clientPeer) sendError(err error) {
+	p.logger.Error("tftp error", "remote", p.remoteAddr.String(), "err", err)
+	if te, ok := err.(*TFTPError); ok {
+		p.write(&wire.Error{Code: te.Code, Msg: te.Msg})
+		p.endTransfer(err)
+		return
+	}
+	p.write(&wire.Error{Code: 0, Msg: err.Error()})
+	p.endTransfer(err)
+}
+
+// beginTransfer records that a RRQ/WRQ was accepted and fires
+// hooks.OnTransferStart; call it once the underlying file is open, not on
+// every retried packet.
+func (p *clientPeer) beginTransfer(fileName string, op Op) {
+	p.fileName = fileName
+	p.op = op
+	p.startTime = time.Now()
+	p.started = true
+	p.hooks.onTransferStart(p.remoteAddr.String(), fileName, op)
+}
+
+// endTransfer fires hooks.OnTransferEnd exactly once for the transfer
+// beginTransfer started, whether it succeeded (err == nil) or not.
+func (p *clientPeer) endTransfer(err error) {
+	if !p.started || p.ended {
+		return
+	}
+	p.ended = true
+	p.hooks.onTransferEnd(p.remoteAddr.String(), p.fileName, p.op, p.transferredBytes, time.Since(p.startTime), err)
+}
+
+// dataBuffer returns a scratch buffer sized to blockSize for an outgoing
+// DATA payload, reusing one from sendPool when possible instead of
+// allocating fresh for every block of a transfer. The pool is recreated
+// whenever blockSize changes (e.g. after option negotiation shrinks it),
+// since a buffer sized for the old blockSize can't be reused for the new
+// one.
+func (p *clientPeer) dataBuffer() []byte {
+	if p.sendPool == nil || p.sendBufSize != p.blockSize {
+		p.sendBufSize = p.blockSize
+		size := p.blockSize
+		p.sendPool = &sync.Pool{New: func() interface{} { return make([]byte, size) }}
+	}
+	return p.sendPool.Get().([]byte)[:p.sendBufSize]
+}
+
+// gotExpectedPacket resets the retry counter; call it whenever the peer
+// advances state (i.e. the last thing we sent was actually acknowledged).
+func (p *clientPeer) gotExpectedPacket() {
+	p.retries = 0
+}
+
+// newClientPeer opens a fresh ephemeral UDP socket for this transfer, as
+// RFC 1350 requires a server to pick a new TID per-transfer rather than
+// keep answering from the well-known port.
+func newClientPeer(remoteAddr net.Addr, fileHandler FileHandler, maxRetries, maxBlockSize int, logger *slog.Logger, hooks Hooks) (*clientPeer, error) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if maxBlockSize <= 0 {
+		maxBlockSize = defaultBlockSizeCeiling
+	}
+	if logger == nil {
+		logger = discardLogger()
+	}
+	return &clientPeer{
+		remoteAddr:       remoteAddr,
+		conn:             conn,
+		channel:          wire.NewUDPChannel(conn),
+		keepaliveTime:    time.Now(),
+		blockSize:        legacyBlockSize,
+		blockSizeCeiling: maxBlockSize,
+		timeout:          10,
+		windowSize:       1,
+		maxRetries:       maxRetries,
+		fileHandler:      fileHandler,
+		logger:           logger,
+		hooks:            hooks,
+	}, nil
+}
+
+func (p *clientPeer) Close() error {
+	if p.readSeekCloser != nil {
+		p.readSeekCloser.Close()
+	}
+	if p.writeSeekCloser != nil {
+		p.writeSeekCloser.Close()
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	return nil
+}
+
+// run drives the whole transfer on the peer's own channel: it keeps
+// reading until the transfer finishes or idles out, enforcing that only
+// packets from the negotiated TID (remoteAddr) are allowed to drive the
+// state machine; anything else gets error code 5 without disturbing the
+// transfer.
+func (p *clientPeer) run(first wire.Packet) {
+	p.keepaliveTime = time.Now()
+	p.dispatch(first)
+	if p.ended {
+		p.Close()
+		return
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+		pkt, raddr, err := p.channel.ReadPacket(ctx)
+		cancel()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok {
+				if netErr.Timeout() && p.retryOrAbort() {
+					continue
+				}
+				p.Close()
+				return
+			}
+			// Malformed packet: RFC 1350 says to just ignore garbage,
+			// not tear down an otherwise-healthy transfer over it.
+			p.logger.Warn("ignoring malformed packet", "remote", raddr, "err", err)
+			continue
+		}
+		if raddr.String() != p.remoteAddr.String() {
+			// Wrong TID: reply with error 5 and keep serving the real peer.
+			p.channel.WritePacket(context.Background(), unknownTIDError(), raddr)
+			continue
+		}
+		p.keepaliveTime = time.Now()
+		p.dispatch(pkt)
+		if p.ended {
+			// Transfer finished (final ACK or final DATA block): close out
+			// the file handle now, so a WriteFile's bytes are actually
+			// committed instead of sitting open until an idle timeout.
+			p.Close()
+			return
+		}
+	}
+}
+
+// retryOrAbort is called when no ACK/DATA arrived before the timeout. For
+// a WRQ ACK or a handshake OACK it just retransmits that one packet, up to
+// maxRetries times. For an RRQ send window (lastSent is a *wire.Data) it
+// instead resends the whole outstanding window [sendBase, sendBase+
+// windowSize) and, same as client.go's PutContext does on its own send
+// timeouts, halves windowSize first: a lost whole window is usually a
+// sign the path can't sustain it, not just one unlucky packet. It reports
+// whether the peer should keep waiting; once retries are exhausted it
+// sends an error packet and returns false so run can close the transfer.
+func (p *clientPeer) retryOrAbort() bool {
+	if p.lastSent == nil {
+		p.logger.Debug("timeout with nothing to retransmit", "remote", p.remoteAddr.String())
+		return false
+	}
+	if p.retries >= p.maxRetries {
+		p.logger.Warn("giving up on peer", "retries", p.retries, "remote", p.remoteAddr.String())
+		p.sendError(fmt.Errorf("timed out waiting for peer"))
+		return false
+	}
+	p.retries++
+	if _, ok := p.lastSent.(*wire.Data); ok {
+		if p.windowSize > 1 {
+			p.windowSize /= 2
+			if p.windowSize < 1 {
+				p.windowSize = 1
+			}
+		}
+		p.logger.Debug("timeout, resending window", "attempt", p.retries, "from", p.sendBase, "windowSize", p.windowSize, "remote", p.remoteAddr.String())
+		p.sendWindow(p.sendBase)
+		return true
+	}
+	p.logger.Debug("timeout, retransmitting", "attempt", p.retries, "remote", p.remoteAddr.String())
+	p.channel.WritePacket(context.Background(), p.lastSent, p.remoteAddr)
+	return true
+}
+
+// dispatch runs the state machine for one incoming packet, type-switching
+// on the Packet the Channel already decoded instead of hand-parsing bytes.
+func (p *clientPeer) dispatch(pkt wire.Packet) {
+	switch t := pkt.(type) {
+	case *wire.RRQ:
+		p.HandleReadHandshake(t)
+	case *wire.WRQ:
+		p.HandleWriteHandshake(t)
+	case *wire.Data:
+		p.HandleWriteData(t)
+	case *wire.Ack:
+		p.HandleReadAck(t)
+	case *wire.Error:
+		err := fmt.Errorf("code:%d, msg:%s", t.Code, t.Msg)
+		p.logger.Warn("peer reported error", "remote", p.remoteAddr.String(), "err", err)
+		p.endTransfer(err)
+	}
+}
+
+func (p *clientPeer) HandleReadHandshake(rrq *wire.RRQ) {
+	fileName := rrq.FileName
+	if exist, err := p.fileHandler.IsFileExist(p.remoteAddr.String(), fileName); err != nil || !exist {
+		if err != nil {
+			p.sendError(err)
+			return
+		}
+		p.write(fileNotFoundError())
+		return
+	}
+
+	var err error
+	if p.readSeekCloser == nil {
+		if p.readSeekCloser, err = p.fileHandler.ReadFile(p.remoteAddr.String(), fileName); err != nil {
+			p.sendError(err)
+			return
+		}
+	}
+
+	mode := strings.ToLower(rrq.Mode)
+	if mode != "octet" && mode != "netascii" {
+		p.write(illegalOperationError())
+		return
+	}
+	p.mode = mode
+	if mode == "netascii" {
+		p.netReader = newNetasciiReader(p.readSeekCloser)
+		p.netBlockCursor = 1
+	}
+
+	hasOption := len(rrq.Options) > 0
+	if err := p.applyReadOptions(rrq.Options); err != nil {
+		p.sendError(err)
+		return
+	}
+
+	p.beginTransfer(fileName, OpRead)
+	p.sendBase = 1
+	if hasOption {
+		p.sendOptionAck()
+	} else {
+		p.sendWindow(1)
+	}
+}
+
+// applyReadOptions takes blksize (capped at blockSizeCeiling) and
+// windowsize as the client asked, lowers timeout to the client's value if
+// it is smaller, and (if tsize was requested, outside netascii mode)
+// reports the real file size via Seek.
+func (p *clientPeer) applyReadOptions(opts []wire.Option) error {
+	sawBlockSize := false
+	for _, opt := range opts {
+		switch opt.Name {
+		case "blksize":
+			size, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			// RFC2348 define the minimum size is 8byte
+			if size < 8 {
+				return fmt.Errorf("the value of blksize is too small")
+			}
+			if size > p.blockSizeCeiling {
+				size = p.blockSizeCeiling
+			}
+			p.blockSize = size
+			sawBlockSize = true
+		case "timeout":
+			timeout, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			// RFC2349 define the minimum timeout is 1second.
+			if timeout < 1 {
+				return fmt.Errorf("the value of timeout is invalid")
+			}
+			if timeout < p.timeout {
+				p.timeout = timeout
+			}
+		case "tsize":
+			// netascii's CRLF expansion makes the on-wire size depend on
+			// file content, not just length; rather than scan the whole
+			// file to compute it, just decline to report it.
+			if p.mode == "netascii" {
+				continue
+			}
+			tsize, err := p.readSeekCloser.Seek(0, 2)
+			if err != nil {
+				return err
+			}
+			p.transferSize = int(tsize)
+		case "windowsize":
+			size, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			if size < 1 || size > 65535 {
+				return fmt.Errorf("the value of windowsize is invalid")
+			}
+			p.windowSize = size
+		default:
+			return fmt.Errorf("unknown option")
+		}
+	}
+	// The client showed option support by sending some option, just not
+	// blksize, so there's no legacy-client reason to stay at
+	// legacyBlockSize: default it up to something more modern instead.
+	if len(opts) > 0 && !sawBlockSize {
+		p.blockSize = modernBlockSize
+	}
+	return nil
+}
+
+func (p *clientPeer) sendOptionAck() {
+	opts := []wire.Option{
+		{Name: "blksize", Value: strconv.Itoa(p.blockSize)},
+		{Name: "timeout", Value: strconv.Itoa(p.timeout)},
+	}
+	if p.op != OpRead || p.mode != "netascii" {
+		opts = append(opts, wire.Option{Name: "tsize", Value: strconv.Itoa(p.transferSize)})
+	}
+	if p.windowSize > 1 {
+		opts = append(opts, wire.Option{Name: "windowsize", Value: strconv.Itoa(p.windowSize)})
+	}
+	p.write(&wire.OAck{Options: opts})
+}
+
+// sendWindow sends DATA blocks [from, from+windowSize) that fit within the
+// file, used both for the very first block and after sliding the window on
+// an ACK. It stops early at EOF (the short final block ends the transfer).
+func (p *clientPeer) sendWindow(from uint16) {
+	for i := 0; i < p.windowSize; i++ {
+		blockID := from + uint16(i)
+		buf := p.dataBuffer()
+		var n int
+		var err error
+		if p.mode == "netascii" {
+			n, err = p.readNetasciiBlock(blockID, buf)
+		} else {
+			// Always seek, even for block 1: applyReadOptions may have
+			// already moved the cursor to EOF to answer a tsize request,
+			// so assuming it's still parked at the start isn't safe.
+			if _, serr := p.readSeekCloser.Seek(int64(blockID-1)*int64(p.blockSize), 0); serr != nil {
+				p.sendError(serr)
+				return
+			}
+			n, err = p.readSeekCloser.Read(buf)
+		}
+		if err != nil && err != io.EOF {
+			p.sendError(err)
+			return
+		}
+		p.write(&wire.Data{BlockID: blockID, Data: buf[:n]})
+		p.transferredBytes += int64(n)
+		p.hooks.onBlock(blockID, n)
+		p.logger.Debug("sent data block", "blockID", blockID, "remote", p.remoteAddr.String())
+		if n < p.blockSize {
+			// final (possibly short) block of the window; nothing more to send
+			return
+		}
+	}
+}
+
+// readNetasciiBlock fills buf with the next blockSize netascii-translated
+// bytes for blockID. Unlike the octet path, a block can't be located by
+// seeking the underlying file to blockID*blockSize, since CRLF expansion
+// means wire offsets don't correspond 1:1 to file offsets; instead
+// netReader is kept positioned at the next block it's expected to emit,
+// and only rewound (by replaying the translation from the start) when the
+// caller actually needs an earlier block, e.g. retransmitting after an
+// out-of-order ACK.
+func (p *clientPeer) readNetasciiBlock(blockID uint16, buf []byte) (int, error) {
+	if blockID != p.netBlockCursor {
+		if err := p.rewindNetasciiTo(blockID); err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(p.netReader, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	p.netBlockCursor++
+	return n, err
+}
+
+func (p *clientPeer) rewindNetasciiTo(blockID uint16) error {
+	if _, err := p.readSeekCloser.Seek(0, 0); err != nil {
+		return err
+	}
+	p.netReader = newNetasciiReader(p.readSeekCloser)
+	discard := make([]byte, p.blockSize)
+	for cur := uint16(1); cur < blockID; cur++ {
+		if _, err := io.ReadFull(p.netReader, discard); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+	p.netBlockCursor = blockID
+	return nil
+}
+
+func (p *clientPeer) HandleReadAck(ack *wire.Ack) {
+	if p.readSeekCloser == nil {
+		p.write(illegalOperationError())
+		return
+	}
+
+	blockID := ack.BlockID
+	p.logger.Debug("got ack", "blockID", blockID, "remote", p.remoteAddr.String())
+
+	if blockID < p.sendBase-1 {
+		// an out-of-order/duplicate ACK: the sorcerer's apprentice recovery
+		// is to resend the window starting right after the acked block.
+		p.sendWindow(blockID + 1)
+		return
+	}
+
+	// slide the window: everything up to and including blockID is confirmed.
+	p.gotExpectedPacket()
+	p.sendBase = blockID + 1
+	if last, ok := p.lastSent.(*wire.Data); ok && len(last.Data) < p.blockSize && blockID >= last.BlockID {
+		// the client just acked a short (final) block: nothing left to send.
+		p.endTransfer(nil)
+		return
+	}
+	p.sendWindow(p.sendBase)
+}
+
+func (p *clientPeer) HandleWriteHandshake(wrq *wire.WRQ) {
+	fileName := wrq.FileName
+	if exist, err := p.fileHandler.IsFileExist(p.remoteAddr.String(), fileName); err != nil || exist {
+		if err != nil {
+			p.sendError(err)
+			return
+		}
+		p.write(fileExistsError())
+		return
+	}
+
+	var err error
+	if p.writeSeekCloser == nil {
+		if p.writeSeekCloser, err = p.fileHandler.WriteFile(p.remoteAddr.String(), fileName); err != nil {
+			p.sendError(err)
+			return
+		}
+	}
+
+	mode := strings.ToLower(wrq.Mode)
+	if mode != "octet" && mode != "netascii" {
+		p.write(illegalOperationError())
+		return
+	}
+	p.mode = mode
+	if mode == "netascii" {
+		p.netWriter = newNetasciiWriter(p.writeSeekCloser)
+	}
+
+	hasOption := len(wrq.Options) > 0
+	if err := p.applyWriteOptions(wrq.Options); err != nil {
+		p.sendError(err)
+		return
+	}
+
+	p.beginTransfer(fileName, OpWrite)
+	if hasOption {
+		p.sendOptionAck()
+	} else {
+		p.ackBlock(0)
+	}
+}
+
+// applyWriteOptions is the WRQ counterpart to applyReadOptions: tsize here
+// is the size the client says it intends to send, taken at face value
+// rather than measured.
+func (p *clientPeer) applyWriteOptions(opts []wire.Option) error {
+	sawBlockSize := false
+	for _, opt := range opts {
+		switch opt.Name {
+		case "blksize":
+			size, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			// RFC2348 define the minimum size is 8byte
+			if size < 8 {
+				return fmt.Errorf("the value of blksize is too small")
+			}
+			if size > p.blockSizeCeiling {
+				size = p.blockSizeCeiling
+			}
+			p.blockSize = size
+			sawBlockSize = true
+		case "timeout":
+			timeout, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			// RFC2349 define the minimum timeout is 1second.
+			if timeout < 1 {
+				return fmt.Errorf("the value of timeout is invalid")
+			}
+			if timeout < p.timeout {
+				p.timeout = timeout
+			}
+		case "tsize":
+			tsize, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			p.transferSize = tsize
+		case "windowsize":
+			size, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return err
+			}
+			if size < 1 || size > 65535 {
+				return fmt.Errorf("the value of windowsize is invalid")
+			}
+			p.windowSize = size
+		default:
+			return fmt.Errorf("unknown option: %s", opt.Name)
+		}
+	}
+	if len(opts) > 0 && !sawBlockSize {
+		p.blockSize = modernBlockSize
+	}
+	return nil
+}
+
+func (p *clientPeer) HandleWriteData(d *wire.Data) {
+	if p.writeSeekCloser == nil {
+		p.write(illegalOperationError())
+		return
+	}
+
+	blockID := d.BlockID
+	if blockID == 0 {
+		p.write(illegalOperationError())
+		return
+	}
+	p.logger.Debug("got data block", "blockID", blockID, "remote", p.remoteAddr.String())
+
+	final := len(d.Data) < p.blockSize
+
+	if blockID != p.recvHighest+1 {
+		// duplicate DATA: the previous ACK was likely lost, so resend the
+		// ACK for the highest block we actually have. DATA from further
+		// ahead is dropped without an ACK.
+		if blockID <= p.recvHighest {
+			p.ackBlock(p.recvHighest)
+		}
+		return
+	}
+
+	if p.mode == "netascii" {
+		if _, err := p.netWriter.Write(d.Data); err != nil {
+			p.sendError(err)
+			return
+		}
+		if final {
+			if err := p.netWriter.Flush(); err != nil {
+				p.sendError(err)
+				return
+			}
+		}
+		p.recvHighest = blockID
+		p.gotExpectedPacket()
+		p.transferredBytes += int64(len(d.Data))
+		p.hooks.onBlock(blockID, len(d.Data))
+
+		if final || int(blockID)%p.windowSize == 0 {
+			p.ackBlock(blockID)
+		}
+		if final {
+			p.endTransfer(nil)
+		}
+		return
+	}
+
+	if _, err := p.writeSeekCloser.Seek(int64(blockID-1)*int64(p.blockSize), 0); err != nil {
+		p.sendError(err)
+		return
+	}
+	if _, err := p.writeSeekCloser.Write(d.Data); err != nil {
+		p.sendError(err)
+		return
+	}
+	p.recvHighest = blockID
+	p.gotExpectedPacket()
+	p.transferredBytes += int64(len(d.Data))
+	p.hooks.onBlock(blockID, len(d.Data))
+
+	// Only ACK every windowSize blocks, or immediately on the final short block.
+	if final || int(blockID)%p.windowSize == 0 {
+		p.ackBlock(blockID)
+	}
+	if final {
+		p.endTransfer(nil)
+	}
+}
+
+func (p *clientPeer) ackBlock(blockID uint16) {
+	p.write(&wire.Ack{BlockID: blockID})
+	p.logger.Debug("sent ack", "blockID", blockID, "remote", p.remoteAddr.String())
+}
@@ -0,0 +1,101 @@
+package gotftp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	wire "github.com/eahydra/gotftp/packet"
+	"github.com/eahydra/gotftp/packet/memchannel"
+)
+
+// newTestPeer wires up a clientPeer's send side over one end of a
+// memchannel Pipe, serving fileName out of handler, so a test can drive
+// its RRQ send-window machinery directly without a real UDP socket or a
+// full run() loop.
+func newTestPeer(t *testing.T, handler *memFileHandler, fileName string, blockSize, windowSize int) (*clientPeer, *memchannel.Channel) {
+	t.Helper()
+	peerAddr := memchannel.Addr("peer")
+	testAddr := memchannel.Addr("test")
+	peerCh, testCh := memchannel.NewPipe(peerAddr, testAddr, 16)
+	t.Cleanup(func() {
+		peerCh.Close()
+		testCh.Close()
+	})
+
+	rsc, err := handler.ReadFile(testAddr.String(), fileName)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	p := &clientPeer{
+		remoteAddr:     testAddr,
+		channel:        peerCh,
+		blockSize:      blockSize,
+		windowSize:     windowSize,
+		maxRetries:     3,
+		fileHandler:    handler,
+		readSeekCloser: rsc,
+		logger:         discardLogger(),
+	}
+	p.beginTransfer(fileName, OpRead)
+	p.sendBase = 1
+	return p, testCh
+}
+
+func recvAllData(t *testing.T, ch *memchannel.Channel, n int) []*wire.Data {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got := make([]*wire.Data, 0, n)
+	for i := 0; i < n; i++ {
+		pkt, _, err := ch.ReadPacket(ctx)
+		if err != nil {
+			t.Fatalf("ReadPacket %d/%d: %v", i+1, n, err)
+		}
+		d, ok := pkt.(*wire.Data)
+		if !ok {
+			t.Fatalf("packet %d/%d: got %T, want *wire.Data", i+1, n, pkt)
+		}
+		got = append(got, d)
+	}
+	return got
+}
+
+// TestRetryOrAbortResendsWholeWindow covers the RFC 7440 gap where a
+// server send-window timeout only ever retransmitted the single last DATA
+// block (peer.write overwrites lastSent on every call inside sendWindow's
+// loop), instead of the whole outstanding [sendBase, sendBase+windowSize)
+// range: a client that's missing an earlier block in the window could
+// never recover.
+func TestRetryOrAbortResendsWholeWindow(t *testing.T) {
+	handler := newMemFileHandler()
+	handler.files["f.bin"] = []byte("AAAAAAAABBBBBBBBCCCCCCCCDDDDDDDD") // 4 x 8-byte blocks
+	p, testCh := newTestPeer(t, handler, "f.bin", 8, 4)
+
+	p.sendWindow(1)
+	first := recvAllData(t, testCh, 4)
+	for i, d := range first {
+		if d.BlockID != uint16(i+1) {
+			t.Fatalf("initial block %d: got BlockID %d, want %d", i, d.BlockID, i+1)
+		}
+	}
+
+	if !p.retryOrAbort() {
+		t.Fatalf("retryOrAbort returned false, want true (retries not yet exhausted)")
+	}
+
+	if p.windowSize != 2 {
+		t.Fatalf("windowSize after one timeout = %d, want 2 (halved like client.go's PutContext)", p.windowSize)
+	}
+
+	// windowSize just halved to 2, so the resend is blocks 1-2, not a
+	// single block (the bug) and not the original 4-block window (that
+	// window no longer exists once windowSize shrinks) — same contract
+	// client.go's PutContext already honors on its own send timeouts.
+	resent := recvAllData(t, testCh, 2)
+	for i, d := range resent {
+		if d.BlockID != uint16(i+1) {
+			t.Fatalf("resent block %d: got BlockID %d, want %d (whole outstanding window should be retransmitted, not just the last block)", i, d.BlockID, i+1)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package gotftp
 
 import (
+	"context"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
+
+	wire "github.com/eahydra/gotftp/packet"
 )
 
 type ReadSeekCloser interface {
@@ -25,21 +29,40 @@ type FileHandler interface {
 	IsFileExist(remoteAddr, fileName string) (exist bool, err error)
 }
 
-type clientPacket struct {
-	data       []byte
-	remoteAddr net.Addr
-}
-
 type Server struct {
+	// closed is read and written under lock, alongside peerMap: Close and
+	// Shutdown both race an in-flight RunContext accept loop to decide
+	// whether there's still a socket worth closing.
 	closed      bool
 	conn        net.PacketConn
 	fileHandler FileHandler
 	readTimeout time.Duration
-	packetChan  chan clientPacket
-	done        chan struct{}
-	peerMap     map[string]*clientPeer
-	lock        sync.Mutex
-	pool        *sync.Pool
+	// MaxRetries bounds how many times a clientPeer retransmits after an
+	// unanswered timeout (the last OACK/ACK, or the outstanding DATA
+	// window) before it aborts the transfer with an error packet.
+	// Defaults to defaultMaxRetries.
+	MaxRetries int
+	// MaxBlockSize caps the blksize a clientPeer will OACK back to a
+	// client, even if the client asked for more: too large a block risks
+	// silent IP fragmentation on real networks. Defaults to
+	// defaultBlockSizeCeiling (1468 bytes, safely under a typical
+	// 1500-byte Ethernet MTU minus IP/UDP headers).
+	MaxBlockSize int
+	// Logger receives diagnostic output from every clientPeer this server
+	// hands off to; any *slog.Logger works, including the default one
+	// (slog.Default()). Defaults to a logger that discards everything.
+	Logger *slog.Logger
+	// Hooks lets an operator observe transfers (start/end/per-block) for
+	// metrics, without forking the package. Zero value does nothing.
+	Hooks   Hooks
+	done    chan struct{}
+	peerMap map[string]*clientPeer
+	lock    sync.Mutex
+	pool    *sync.Pool
+	// peerWG tracks every clientPeer goroutine spawned by RunContext, so
+	// Shutdown can wait for in-flight transfers to drain instead of
+	// cutting them off.
+	peerWG sync.WaitGroup
 }
 
 func allocateBuffer() interface{} {
@@ -55,82 +78,141 @@ func NewServer(addr string, fileHandler FileHandler, readTimeout time.Duration)
 		conn:        conn,
 		fileHandler: fileHandler,
 		readTimeout: readTimeout,
+		MaxRetries:  defaultMaxRetries,
+		Logger:      discardLogger(),
 		done:        make(chan struct{}, 1),
-		packetChan:  make(chan clientPacket, 1024),
 		peerMap:     make(map[string]*clientPeer),
 		pool:        &sync.Pool{New: allocateBuffer},
 	}, nil
 }
 
+// LocalAddr returns the address the server is listening on, useful when
+// NewServer was given an ephemeral port (":0") and the caller needs to
+// know which one got picked.
+func (s *Server) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
 func (s *Server) Close() error {
+	s.lock.Lock()
 	if s.closed {
+		s.lock.Unlock()
 		return nil
 	}
 	s.closed = true
 	close(s.done)
+	for k, v := range s.peerMap {
+		v.Close()
+		delete(s.peerMap, k)
+	}
+	s.lock.Unlock()
 	return s.conn.Close()
 }
 
-func (s *Server) removeClientPeer() {
-	for {
-		select {
-		case <-time.After(time.Duration(100) * time.Millisecond):
-			{
-				now := time.Now()
-				s.lock.Lock()
-				for k, v := range s.peerMap {
-					if now.Sub(v.keepaliveTime) > time.Duration(v.timeout)*time.Second {
-						logln("timeout, remote:", v.remoteAddr.String())
-						v.Close()
-						delete(s.peerMap, k)
-					}
-				}
-				s.lock.Unlock()
-			}
-		case <-s.done:
-			{
-				return
-			}
-		}
+// Shutdown stops RunContext from accepting any new transfer, then waits
+// for every clientPeer already handed off to finish on its own. If ctx is
+// done first, it hard-closes every still-running clientPeer's ephemeral
+// socket (the listening socket is already closed by then) instead of
+// waiting any longer.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
 	}
-}
+	s.closed = true
+	close(s.done)
+	s.lock.Unlock()
 
-func (s *Server) work() {
-	for {
-		select {
-		case r, ok := <-s.packetChan:
-			{
-				if ok {
-					var p *clientPeer
-					s.lock.Lock()
-					if p, ok = s.peerMap[r.remoteAddr.String()]; !ok {
-						p = newClientPeer(r.remoteAddr, s.fileHandler)
-						s.peerMap[r.remoteAddr.String()] = p
-					}
-					s.lock.Unlock()
-					p.Dispatch(s.conn, r.data)
-					s.pool.Put(r.data[:cap(r.data)])
-				}
-			}
-		case <-s.done:
-			{
-				return
-			}
+	if err := s.conn.Close(); err != nil {
+		return err
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.peerWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		for k, v := range s.peerMap {
+			v.Close()
+			delete(s.peerMap, k)
 		}
+		s.lock.Unlock()
+		return ctx.Err()
 	}
 }
 
+func (s *Server) registerPeer(p *clientPeer) {
+	s.lock.Lock()
+	s.peerMap[p.remoteAddr.String()] = p
+	s.lock.Unlock()
+}
+
+func (s *Server) unregisterPeer(p *clientPeer) {
+	s.lock.Lock()
+	delete(s.peerMap, p.remoteAddr.String())
+	s.lock.Unlock()
+}
+
+// Run only ever reads RRQ/WRQ on the well-known listening socket, as
+// RFC 1350 requires the server to answer each transfer from a freshly
+// chosen TID rather than keep talking from the well-known port. Once a
+// request comes in, it hands off to a clientPeer with its own ephemeral
+// UDP socket and lets it drive the rest of the transfer in its own
+// goroutine; everything else that shows up here (stray DATA/ACK from an
+// in-flight transfer, say) is simply not our concern anymore.
+//
+// Run never returns until the listening socket errs out; use RunContext to
+// stop accepting new transfers on demand.
 func (s *Server) Run() error {
-	go s.removeClientPeer()
-	go s.work()
+	return s.RunContext(context.Background())
+}
+
+// earlierDeadline returns the sooner of now+fallback and ctx's own
+// deadline, so a blocking read honors whichever bound fires first.
+func earlierDeadline(ctx context.Context, fallback time.Duration) time.Time {
+	deadline := time.Now().Add(fallback)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
+// RunContext is Run, but also returns once ctx is done, closing out the
+// accept loop without touching any clientPeer already handed off.
+func (s *Server) RunContext(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
 		if s.readTimeout != 0 {
-			s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+			s.conn.SetReadDeadline(earlierDeadline(ctx, s.readTimeout))
 		}
 
 		buff := s.pool.Get().([]byte)
 		n, raddr, err := s.conn.ReadFrom(buff)
 		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				s.pool.Put(buff)
+				return ctxErr
+			}
 			if netErr, ok := err.(net.Error); ok {
 				if netErr.Timeout() {
 					s.pool.Put(buff)
@@ -140,11 +222,35 @@ func (s *Server) Run() error {
 			return err
 		}
 
-		select {
-		case <-s.done:
-			return nil
-		case s.packetChan <- clientPacket{buff[:n], raddr}:
+		data := make([]byte, n)
+		copy(data, buff[:n])
+		s.pool.Put(buff[:cap(buff)])
+
+		pkt, err := wire.Unmarshal(data)
+		if err != nil {
+			continue
+		}
+		switch pkt.(type) {
+		case *wire.RRQ, *wire.WRQ: // only RRQ/WRQ may open a new transfer
+		default:
+			continue
+		}
+
+		logger := s.Logger
+		if logger == nil {
+			logger = discardLogger()
+		}
+		peer, err := newClientPeer(raddr, s.fileHandler, s.MaxRetries, s.MaxBlockSize, logger, s.Hooks)
+		if err != nil {
+			logger.Warn("failed to open ephemeral socket", "remote", raddr.String(), "err", err)
+			continue
 		}
+		s.registerPeer(peer)
+		s.peerWG.Add(1)
+		go func() {
+			defer s.peerWG.Done()
+			defer s.unregisterPeer(peer)
+			peer.run(pkt)
+		}()
 	}
-	return nil
 }